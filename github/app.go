@@ -0,0 +1,175 @@
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package github
+
+import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// appJWTLifetime - GitHub rejects app JWTs that expire more than 10 minutes after they are issued
+	appJWTLifetime = 9 * time.Minute
+	// appClockSkew - the JWT is backdated so a server clock slightly ahead of GitHub's does not make it invalid
+	appClockSkew = time.Minute
+	// tokenRefreshMargin - installation tokens are replaced this long before they expire so a call never starts with a token about to lapse
+	tokenRefreshMargin = 5 * time.Minute
+)
+
+// ErrNoInstallation - the webhook did not come from an installation of the app so there is no installation token for it
+var ErrNoInstallation = errors.New("github app called without an installation id")
+
+// AppConfig - struct
+type AppConfig struct {
+	ID             int64  `json:"id"`
+	PrivateKeyFile string `json:"private_key_file"`
+}
+
+// App - authenticates as a GitHub App. Each installation's access token is cached until shortly before it expires.
+type App struct {
+	id     int64
+	key    *rsa.PrivateKey
+	url    string
+	client *http.Client
+
+	// mut guards the map only, each installation is locked while its token is requested so a slow installation holds up no other
+	mut           sync.Mutex
+	installations map[int64]*installation
+}
+
+// installation - the cached token of an installation
+type installation struct {
+	mut   sync.Mutex
+	token installationToken
+}
+
+type installationToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// NewApp - reads the app's PEM encoded private key, url defaults to the public GitHub API
+func NewApp(url string, client *http.Client, cfg AppConfig) (*App, error) {
+	b, err := ioutil.ReadFile(cfg.PrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(b)
+	if err != nil {
+		return nil, fmt.Errorf("github app private key: %s", err.Error())
+	}
+
+	if url == "" {
+		url = DefaultURL
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &App{
+		id:            cfg.ID,
+		key:           key,
+		url:           strings.TrimSuffix(url, "/"),
+		client:        client,
+		installations: make(map[int64]*installation),
+	}, nil
+}
+
+// Token - the access token of an installation, a new one is requested when there is none cached or it is about to expire
+func (a *App) Token(ctx context.Context, installationID int64) (string, error) {
+	if installationID == 0 {
+		return "", ErrNoInstallation
+	}
+
+	inst := a.installation(installationID)
+	inst.mut.Lock()
+	defer inst.mut.Unlock()
+
+	if inst.token.Token != "" && time.Now().Add(tokenRefreshMargin).Before(inst.token.ExpiresAt) {
+		return inst.token.Token, nil
+	}
+
+	t, err := a.requestToken(ctx, installationID)
+	if err != nil {
+		return "", err
+	}
+	inst.token = t
+
+	return t.Token, nil
+}
+
+func (a *App) installation(installationID int64) *installation {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+
+	inst, ok := a.installations[installationID]
+	if !ok {
+		inst = &installation{}
+		a.installations[installationID] = inst
+	}
+	return inst
+}
+
+// JWT - a token identifying the app itself, used to request installation tokens
+func (a *App) JWT() (string, error) {
+	now := time.Now()
+	claims := jwt.StandardClaims{
+		IssuedAt:  now.Add(-appClockSkew).Unix(),
+		ExpiresAt: now.Add(appJWTLifetime).Unix(),
+		Issuer:    strconv.FormatInt(a.id, 10),
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodRS256, claims).SignedString(a.key)
+}
+
+func (a *App) requestToken(ctx context.Context, installationID int64) (installationToken, error) {
+	var t installationToken
+
+	signed, err := a.JWT()
+	if err != nil {
+		return t, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/app/installations/%d/access_tokens", a.url, installationID), nil)
+	if err != nil {
+		return t, err
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	req.Header.Set("Authorization", "Bearer "+signed)
+
+	if err = send(a.client, req, &t); err != nil {
+		return t, err
+	}
+	if t.Token == "" {
+		return t, fmt.Errorf("github app installation %d: no token returned", installationID)
+	}
+
+	return t, nil
+}
@@ -0,0 +1,199 @@
+// +build unit
+
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package github_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/comcast/github-rally-hook/github"
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var _ = Describe("Authenticating as a GitHub App", func() {
+	var (
+		server  *ghttp.Server
+		key     *rsa.PrivateKey
+		keyFile string
+		app     *github.App
+	)
+
+	// verifyJWT - checks the request is signed by the app
+	verifyJWT := func(w http.ResponseWriter, r *http.Request) {
+		signed := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		var claims jwt.StandardClaims
+		token, err := jwt.ParseWithClaims(signed, &claims, func(t *jwt.Token) (interface{}, error) {
+			return &key.PublicKey, nil
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(token.Method).Should(Equal(jwt.SigningMethodRS256))
+		Expect(claims.Issuer).Should(Equal("1234"))
+		Expect(claims.ExpiresAt - claims.IssuedAt).Should(BeNumerically("<=", 600))
+	}
+
+	accessToken := func(token string, expires time.Duration) http.HandlerFunc {
+		return ghttp.RespondWith(http.StatusCreated, fmt.Sprintf(`{"token": "%s", "expires_at": "%s"}`, token, time.Now().Add(expires).UTC().Format(time.RFC3339)))
+	}
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		server.AllowUnhandledRequests = false
+
+		var err error
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		f, err := ioutil.TempFile("", "app-key")
+		Expect(err).ShouldNot(HaveOccurred())
+		pem.Encode(f, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+		f.Close()
+		keyFile = f.Name()
+
+		app, err = github.NewApp(server.URL(), nil, github.AppConfig{ID: 1234, PrivateKeyFile: keyFile})
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.Remove(keyFile)
+	})
+
+	Context("when a token is asked for twice", func() {
+		BeforeEach(func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/app/installations/42/access_tokens"),
+					verifyJWT,
+					accessToken("inst-42", time.Hour),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/app/installations/43/access_tokens"),
+					verifyJWT,
+					accessToken("inst-43", time.Hour),
+				),
+			)
+		})
+
+		It("should exchange the JWT once per installation and cache the token", func() {
+			Expect(app.Token(context.Background(), 42)).Should(Equal("inst-42"))
+			Expect(app.Token(context.Background(), 42)).Should(Equal("inst-42"))
+			Expect(app.Token(context.Background(), 43)).Should(Equal("inst-43"))
+			Expect(server.ReceivedRequests()).Should(HaveLen(2))
+		})
+	})
+
+	Context("when the cached token is about to expire", func() {
+		BeforeEach(func() {
+			server.AppendHandlers(
+				accessToken("first", time.Minute),
+				accessToken("second", time.Hour),
+			)
+		})
+
+		It("should request a new token", func() {
+			Expect(app.Token(context.Background(), 42)).Should(Equal("first"))
+			Expect(app.Token(context.Background(), 42)).Should(Equal("second"))
+		})
+	})
+
+	Context("when used by a client", func() {
+		BeforeEach(func() {
+			server.AppendHandlers(
+				accessToken("inst-42", time.Hour),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/repos/ABC/data-service/commits/6dcb09b"),
+					ghttp.VerifyHeader(http.Header{"Authorization": []string{"token inst-42"}}),
+					ghttp.RespondWith(http.StatusOK, `{"sha": "6dcb09b"}`),
+				),
+			)
+		})
+
+		It("should call GitHub with the installation token", func() {
+			client := github.NewClient(server.URL(), nil, app)
+
+			commit, err := client.GetCommit(context.Background(), "ABC/data-service", "6dcb09b", 42)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(commit.SHA).Should(Equal("6dcb09b"))
+		})
+	})
+
+	Context("when an installation's token is slow to come", func() {
+		var release chan struct{}
+
+		BeforeEach(func() {
+			release = make(chan struct{})
+			server.RouteToHandler("POST", "/app/installations/42/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-release:
+				case <-r.Context().Done():
+				}
+			})
+			server.RouteToHandler("POST", "/app/installations/43/access_tokens", accessToken("inst-43", time.Hour))
+		})
+
+		AfterEach(func() {
+			close(release)
+		})
+
+		It("should not hold up the tokens of other installations", func() {
+			go app.Token(context.Background(), 42)
+			Eventually(server.ReceivedRequests).Should(HaveLen(1))
+
+			Expect(app.Token(context.Background(), 43)).Should(Equal("inst-43"))
+		})
+
+		It("should give up once the context is cancelled", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			_, err := app.Token(ctx, 42)
+			Expect(err).Should(HaveOccurred())
+		})
+	})
+
+	Context("when the webhook did not come from an installation", func() {
+		It("should not ask GitHub for a token", func() {
+			_, err := app.Token(context.Background(), 0)
+			Expect(err).Should(Equal(github.ErrNoInstallation))
+			Expect(server.ReceivedRequests()).Should(BeEmpty())
+		})
+	})
+
+	Context("when the private key is not valid", func() {
+		It("should fail to create the app", func() {
+			ioutil.WriteFile(keyFile, []byte("not a key"), 0600)
+
+			_, err := github.NewApp(server.URL(), nil, github.AppConfig{ID: 1234, PrivateKeyFile: keyFile})
+			Expect(err).Should(HaveOccurred())
+		})
+	})
+})
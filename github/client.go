@@ -0,0 +1,159 @@
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package github calls back into the GitHub REST API on behalf of the hook.
+package github
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DefaultURL - the GitHub REST API used when the config does not set one, set the url for GitHub Enterprise
+const DefaultURL = "https://api.github.com"
+
+// ErrNoCredentials - no token is available for the call
+var ErrNoCredentials = errors.New("no github credentials configured")
+
+// Config - struct
+type Config struct {
+	URL string `json:"url"`
+	// Token is used for every call when there is no App
+	Token string    `json:"token"`
+	App   AppConfig `json:"app"`
+}
+
+// TokenSource - supplies the token used for calls made on behalf of an installation. installationID is 0 when the webhook did not come from a GitHub App.
+// A token that has to be requested is abandoned when ctx is done.
+type TokenSource interface {
+	Token(ctx context.Context, installationID int64) (string, error)
+}
+
+// StaticToken - the same token for every call, e.g. the personal access token of a bot account
+type StaticToken string
+
+// Token - returns the token, whatever the installation
+func (t StaticToken) Token(ctx context.Context, installationID int64) (string, error) {
+	if t == "" {
+		return "", ErrNoCredentials
+	}
+	return string(t), nil
+}
+
+// NewTokenSource - the credentials in cfg, a GitHub App when one is configured otherwise the token. Nil when there are none.
+func NewTokenSource(cfg Config, client *http.Client) (TokenSource, error) {
+	if cfg.App.ID != 0 {
+		return NewApp(cfg.URL, client, cfg.App)
+	}
+	if cfg.Token != "" {
+		return StaticToken(cfg.Token), nil
+	}
+	return nil, nil
+}
+
+// Error - a response from the GitHub API that was not successful
+type Error struct {
+	StatusCode int    `json:"-"`
+	Method     string `json:"-"`
+	URL        string `json:"-"`
+	Message    string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("github %s %s: %d %s", e.Method, e.URL, e.StatusCode, e.Message)
+}
+
+// Client - calls the GitHub REST API
+type Client struct {
+	url    string
+	client *http.Client
+	tokens TokenSource
+}
+
+// NewClient - client makes every call so retries and timeouts are shared with the caller, url defaults to the public GitHub API
+func NewClient(url string, client *http.Client, tokens TokenSource) *Client {
+	if url == "" {
+		url = DefaultURL
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &Client{
+		url:    strings.TrimSuffix(url, "/"),
+		client: client,
+		tokens: tokens,
+	}
+}
+
+// do - sends body as json to path with the installation's token and decodes the response into v, the call is abandoned when ctx is done
+func (c *Client) do(ctx context.Context, method string, path string, installationID int64, body interface{}, v interface{}) error {
+	token, err := c.tokens.Token(ctx, installationID)
+	if err != nil {
+		return err
+	}
+
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, c.url+path, reader)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	req.Header.Set("Authorization", "token "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return send(c.client, req, v)
+}
+
+// send - makes the request and decodes a successful response into v, anything else is returned as an *Error
+func send(client *http.Client, req *http.Request, v interface{}) error {
+	response, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		apiErr := &Error{StatusCode: response.StatusCode, Method: req.Method, URL: req.URL.Path}
+		json.NewDecoder(response.Body).Decode(apiErr)
+		return apiErr
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	return json.NewDecoder(response.Body).Decode(v)
+}
@@ -0,0 +1,144 @@
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package github
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// perPage - page size asked for when listing commits and files
+const perPage = 100
+
+// Comparison - the commits between two revisions
+type Comparison struct {
+	Status       string   `json:"status"`
+	AheadBy      int      `json:"ahead_by"`
+	BehindBy     int      `json:"behind_by"`
+	TotalCommits int      `json:"total_commits"`
+	Commits      []Commit `json:"commits"`
+}
+
+// Commit - a commit as returned by the commits and compare apis, Files is only returned for a single commit
+type Commit struct {
+	SHA     string `json:"sha"`
+	HTMLURL string `json:"html_url"`
+	Commit  struct {
+		Message   string  `json:"message"`
+		Author    GitUser `json:"author"`
+		Committer GitUser `json:"committer"`
+		Tree      struct {
+			SHA string `json:"sha"`
+		} `json:"tree"`
+	} `json:"commit"`
+	Author    *User  `json:"author"`
+	Committer *User  `json:"committer"`
+	Files     []File `json:"files"`
+}
+
+// GitUser - the name and email recorded in a commit
+type GitUser struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Date  string `json:"date"`
+}
+
+// User - the GitHub account a commit is attributed to, nil when the email does not belong to an account
+type User struct {
+	Login string `json:"login"`
+}
+
+// File - a file changed by a commit, Status is added, modified, removed or renamed
+type File struct {
+	Filename         string `json:"filename"`
+	Status           string `json:"status"`
+	PreviousFilename string `json:"previous_filename,omitempty"`
+}
+
+// Compare - the commits between base and head of repo, given as owner/name. Pages are read until every commit is returned.
+func (c *Client) Compare(ctx context.Context, repo string, base string, head string, installationID int64) (*Comparison, error) {
+	var comparison *Comparison
+
+	for page := 1; ; page++ {
+		var p Comparison
+		path := fmt.Sprintf("/repos/%s/compare/%s...%s?%s", repo, url.PathEscape(base), url.PathEscape(head), pageQuery(page))
+		if err := c.do(ctx, "GET", path, installationID, nil, &p); err != nil {
+			return nil, err
+		}
+
+		if comparison == nil {
+			comparison = &p
+		} else {
+			comparison.Commits = append(comparison.Commits, p.Commits...)
+		}
+
+		if len(p.Commits) == 0 || len(comparison.Commits) >= comparison.TotalCommits {
+			return comparison, nil
+		}
+	}
+}
+
+// GetCommit - a commit of repo with all of its files, the files are read a page at a time
+func (c *Client) GetCommit(ctx context.Context, repo string, sha string, installationID int64) (*Commit, error) {
+	var commit *Commit
+
+	for page := 1; ; page++ {
+		var p Commit
+		path := fmt.Sprintf("/repos/%s/commits/%s?%s", repo, url.PathEscape(sha), pageQuery(page))
+		if err := c.do(ctx, "GET", path, installationID, nil, &p); err != nil {
+			return nil, err
+		}
+
+		if commit == nil {
+			commit = &p
+		} else {
+			commit.Files = append(commit.Files, p.Files...)
+		}
+
+		if len(p.Files) < perPage {
+			return commit, nil
+		}
+	}
+}
+
+// PullRequestCommits - the commits of pull request number in repo, GitHub returns at most 250
+func (c *Client) PullRequestCommits(ctx context.Context, repo string, number int, installationID int64) ([]Commit, error) {
+	var commits []Commit
+
+	for page := 1; ; page++ {
+		var p []Commit
+		path := fmt.Sprintf("/repos/%s/pulls/%d/commits?%s", repo, number, pageQuery(page))
+		if err := c.do(ctx, "GET", path, installationID, nil, &p); err != nil {
+			return nil, err
+		}
+
+		commits = append(commits, p...)
+
+		if len(p) < perPage {
+			return commits, nil
+		}
+	}
+}
+
+func pageQuery(page int) string {
+	return url.Values{
+		"per_page": []string{fmt.Sprint(perPage)},
+		"page":     []string{fmt.Sprint(page)},
+	}.Encode()
+}
@@ -0,0 +1,143 @@
+// +build unit
+
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package github_test
+
+import (
+	"context"
+	"github.com/comcast/github-rally-hook/github"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"io/ioutil"
+	"net/http"
+)
+
+var _ = Describe("Reading commits from GitHub", func() {
+	var (
+		server *ghttp.Server
+		client *github.Client
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		server.AllowUnhandledRequests = false
+		client = github.NewClient(server.URL(), nil, github.StaticToken("abc123"))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe(".Compare", func() {
+		Context("when the commits span more than one page", func() {
+			BeforeEach(func() {
+				p1, err := ioutil.ReadFile("../fixtures/github_compare_page1.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				p2, err := ioutil.ReadFile("../fixtures/github_compare_page2.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/repos/ABC/data-service/compare/e072dd9...3b1f7c8", "page=1&per_page=100"),
+						ghttp.VerifyHeader(http.Header{"Authorization": []string{"token abc123"}}),
+						ghttp.RespondWith(http.StatusOK, string(p1[:])),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/repos/ABC/data-service/compare/e072dd9...3b1f7c8", "page=2&per_page=100"),
+						ghttp.RespondWith(http.StatusOK, string(p2[:])),
+					),
+				)
+			})
+
+			It("should read every page", func() {
+				comparison, err := client.Compare(context.Background(), "ABC/data-service", "e072dd9", "3b1f7c8", 0)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(comparison.TotalCommits).Should(Equal(3))
+				Expect(comparison.Commits).Should(HaveLen(3))
+				Expect(comparison.Commits[2].Commit.Message).Should(Equal("COMPLETES US12345"))
+				Expect(comparison.Commits[0].Author.Login).Should(Equal("octocat"))
+			})
+		})
+
+		Context("when GitHub returns an error", func() {
+			BeforeEach(func() {
+				server.AppendHandlers(
+					ghttp.RespondWith(http.StatusNotFound, `{"message": "Not Found"}`),
+				)
+			})
+
+			It("should return the status and message", func() {
+				_, err := client.Compare(context.Background(), "ABC/data-service", "e072dd9", "3b1f7c8", 0)
+				Expect(err).Should(BeAssignableToTypeOf(&github.Error{}))
+				Expect(err.(*github.Error).StatusCode).Should(Equal(http.StatusNotFound))
+				Expect(err.(*github.Error).Message).Should(Equal("Not Found"))
+			})
+		})
+
+		Context("when there is no token", func() {
+			It("should not call GitHub", func() {
+				client = github.NewClient(server.URL(), nil, github.StaticToken(""))
+
+				_, err := client.Compare(context.Background(), "ABC/data-service", "e072dd9", "3b1f7c8", 0)
+				Expect(err).Should(Equal(github.ErrNoCredentials))
+				Expect(server.ReceivedRequests()).Should(BeEmpty())
+			})
+		})
+
+		Context("when the context is cancelled", func() {
+			It("should not call GitHub", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+
+				_, err := client.Compare(ctx, "ABC/data-service", "e072dd9", "3b1f7c8", 0)
+				Expect(err).Should(HaveOccurred())
+				Expect(server.ReceivedRequests()).Should(BeEmpty())
+			})
+		})
+	})
+
+	Describe(".GetCommit", func() {
+		BeforeEach(func() {
+			c, err := ioutil.ReadFile("../fixtures/github_getCommit.json")
+			if err != nil {
+				Skip(err.Error())
+			}
+
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/repos/ABC/data-service/commits/6dcb09b5b57875f334f61aebed695e2e4193db5e"),
+					ghttp.RespondWith(http.StatusOK, string(c[:])),
+				),
+			)
+		})
+
+		It("should return the commit with its files", func() {
+			commit, err := client.GetCommit(context.Background(), "ABC/data-service", "6dcb09b5b57875f334f61aebed695e2e4193db5e", 0)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(commit.Files).Should(HaveLen(2))
+			Expect(commit.Files[1].PreviousFilename).Should(Equal("config/jobs.go"))
+		})
+	})
+})
@@ -0,0 +1,101 @@
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package github
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// Commit status states
+const (
+	StatePending = "pending"
+	StateSuccess = "success"
+	StateFailure = "failure"
+	StateError   = "error"
+)
+
+// Check run conclusions
+const (
+	ConclusionSuccess        = "success"
+	ConclusionFailure        = "failure"
+	ConclusionNeutral        = "neutral"
+	ConclusionActionRequired = "action_required"
+)
+
+// maxStatusDescription - GitHub rejects commit status descriptions longer than this
+const maxStatusDescription = 140
+
+// Status - a commit status, Context names the status so a later status with the same context replaces it
+type Status struct {
+	State       string `json:"state"`
+	TargetURL   string `json:"target_url,omitempty"`
+	Description string `json:"description,omitempty"`
+	Context     string `json:"context"`
+}
+
+// CheckRun - a check run, only GitHub Apps can create them
+type CheckRun struct {
+	ID         int64           `json:"id,omitempty"`
+	Name       string          `json:"name"`
+	HeadSHA    string          `json:"head_sha"`
+	Status     string          `json:"status,omitempty"`
+	Conclusion string          `json:"conclusion,omitempty"`
+	DetailsURL string          `json:"details_url,omitempty"`
+	HTMLURL    string          `json:"html_url,omitempty"`
+	Output     *CheckRunOutput `json:"output,omitempty"`
+}
+
+// CheckRunOutput - the title and markdown summary shown on the check run
+type CheckRunOutput struct {
+	Title   string `json:"title"`
+	Summary string `json:"summary"`
+	Text    string `json:"text,omitempty"`
+}
+
+// IsApp - whether the client calls GitHub as an App, so can create check runs
+func (c *Client) IsApp() bool {
+	_, ok := c.tokens.(*App)
+	return ok
+}
+
+// CreateStatus - sets a status on sha in repo, the description is shortened to the length GitHub accepts
+func (c *Client) CreateStatus(ctx context.Context, repo string, sha string, status Status, installationID int64) error {
+	if runes := []rune(status.Description); len(runes) > maxStatusDescription {
+		status.Description = string(runes[:maxStatusDescription-1]) + "…"
+	}
+
+	path := fmt.Sprintf("/repos/%s/statuses/%s", repo, url.PathEscape(sha))
+	return c.do(ctx, "POST", path, installationID, status, nil)
+}
+
+// CreateCheckRun - creates a check run in repo, a run created with a conclusion is completed straight away
+func (c *Client) CreateCheckRun(ctx context.Context, repo string, run CheckRun, installationID int64) (*CheckRun, error) {
+	if run.Conclusion != "" && run.Status == "" {
+		run.Status = "completed"
+	}
+
+	var created CheckRun
+	path := fmt.Sprintf("/repos/%s/check-runs", repo)
+	if err := c.do(ctx, "POST", path, installationID, run, &created); err != nil {
+		return nil, err
+	}
+
+	return &created, nil
+}
@@ -0,0 +1,95 @@
+// +build unit
+
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package github_test
+
+import (
+	"context"
+	"github.com/comcast/github-rally-hook/github"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"net/http"
+	"strings"
+)
+
+var _ = Describe("Reporting to GitHub", func() {
+	var (
+		server *ghttp.Server
+		client *github.Client
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		server.AllowUnhandledRequests = false
+		client = github.NewClient(server.URL(), nil, github.StaticToken("abc123"))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe(".CreateStatus", func() {
+		BeforeEach(func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/repos/ABC/data-service/statuses/6dcb09b"),
+					ghttp.VerifyJSONRepresenting(github.Status{
+						State:       github.StateSuccess,
+						Description: strings.Repeat("x", 139) + "…",
+						Context:     "rally",
+					}),
+					ghttp.RespondWith(http.StatusCreated, `{}`),
+				),
+			)
+		})
+
+		It("should shorten a long description to the length GitHub accepts", func() {
+			err := client.CreateStatus(context.Background(), "ABC/data-service", "6dcb09b", github.Status{
+				State:       github.StateSuccess,
+				Description: strings.Repeat("x", 200),
+				Context:     "rally",
+			}, 0)
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+	})
+
+	Describe(".CreateCheckRun", func() {
+		BeforeEach(func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/repos/ABC/data-service/check-runs"),
+					ghttp.VerifyJSON(`{"name": "rally", "head_sha": "6dcb09b", "status": "completed", "conclusion": "success", "output": {"title": "Linked US12345", "summary": "- US12345"}}`),
+					ghttp.RespondWith(http.StatusCreated, `{"id": 4, "name": "rally", "head_sha": "6dcb09b", "status": "completed", "conclusion": "success"}`),
+				),
+			)
+		})
+
+		It("should complete a run created with a conclusion", func() {
+			run, err := client.CreateCheckRun(context.Background(), "ABC/data-service", github.CheckRun{
+				Name:       "rally",
+				HeadSHA:    "6dcb09b",
+				Conclusion: github.ConclusionSuccess,
+				Output:     &github.CheckRunOutput{Title: "Linked US12345", Summary: "- US12345"},
+			}, 0)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(run.ID).Should(Equal(int64(4)))
+		})
+	})
+})
@@ -21,8 +21,9 @@ package integration_test
 
 import (
 	"bytes"
-	"crypto"
-	"github.com/dgrijalva/jwt-go"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"os"
@@ -169,22 +170,17 @@ var _ = Describe("Rally Github Service Integration Test", func() {
 			if err != nil {
 				Skip(err.Error())
 			}
-			signingMethod := jwt.SigningMethodHMAC{
-				"SHA1",
-				crypto.SHA1,
-			}
+			mac := hmac.New(sha256.New, []byte(secretToken))
+			mac.Write(bodyBytes)
+			value := "sha256=" + hex.EncodeToString(mac.Sum(nil))
 
-			value, err := signingMethod.Sign(string(bodyBytes[:]), []byte(secretToken))
-			if err != nil {
-				Skip(err.Error())
-			}
 			client = &http.Client{}
 
 			request, err = http.NewRequest(http.MethodPost, targetURL, bytes.NewReader(bodyBytes))
 			if err != nil {
 				Skip(err.Error())
 			}
-			request.Header.Set("X-Hub-Signature", value)
+			request.Header.Set("X-Hub-Signature-256", value)
 
 		})
 		It("should process the event and validate the payload", func() {
@@ -227,13 +223,13 @@ var _ = Describe("Rally Github Service Integration Test", func() {
 			if err != nil {
 				Skip(err.Error())
 			}
-			request.Header.Set("X-Hub-Signature", "somerandomvalue")
+			request.Header.Set("X-Hub-Signature-256", "sha256=somerandomvalue")
 
 		})
 		It("should reject the event and return an error", func() {
 			response, err = client.Do(request)
 			Expect(err).ShouldNot(HaveOccurred())
-			Expect(response.StatusCode).Should(Equal(500))
+			Expect(response.StatusCode).Should(Equal(401))
 		})
 	})
 
@@ -0,0 +1,141 @@
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rally
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// artifactBatchSize - most formatted ids queried in one request, matches the default WSAPI page size so a batch is never paged
+const artifactBatchSize = 20
+
+// defaultArtifactTypes - formatted id prefixes and the WSAPI type each one is looked up as
+var defaultArtifactTypes = map[string]string{
+	"D":  "defect",
+	"DE": "defect",
+	"DS": "defectsuite",
+	"TA": "task",
+	"TC": "testcase",
+	"S":  "hierarchicalrequirement",
+	"US": "hierarchicalrequirement",
+}
+
+// ArtifactCfg - struct
+type ArtifactCfg struct {
+	// Types maps a formatted id prefix to its WSAPI type, e.g. "F": "portfolioitem/feature". Replaces the default prefixes when set.
+	Types map[string]string `json:"types"`
+	// Pattern matches a formatted id, the first capture group must be the prefix. When the pattern has groups named
+	// id and prefix those are used instead of the whole match and the first group. Built from Types when empty.
+	Pattern string `json:"pattern"`
+}
+
+// artifactMatcher - finds formatted ids in text and resolves their WSAPI type
+type artifactMatcher struct {
+	regex  *regexp.Regexp
+	types  map[string]string
+	id     int
+	prefix int
+}
+
+func newArtifactMatcher(cfg ArtifactCfg) (*artifactMatcher, error) {
+	types := cfg.Types
+	if len(types) == 0 {
+		types = defaultArtifactTypes
+	}
+
+	pattern := cfg.Pattern
+	if pattern == "" {
+		pattern = artifactPattern(types)
+	}
+
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid artifact pattern: %s", err.Error())
+	}
+
+	if regex.NumSubexp() < 1 {
+		return nil, fmt.Errorf("artifact pattern %s must capture the prefix", pattern)
+	}
+
+	m := &artifactMatcher{
+		regex:  regex,
+		types:  types,
+		id:     0,
+		prefix: 1,
+	}
+	for i, name := range regex.SubexpNames() {
+		switch name {
+		case "id":
+			m.id = i
+		case "prefix":
+			m.prefix = i
+		}
+	}
+
+	return m, nil
+}
+
+// artifactPattern - builds a pattern matching any of the prefixes followed by digits, not preceded by a letter or digit.
+// Underscores count as separators so feature/US123_login finds US123, which \b would not. The separator before the
+// id is part of the match, the id itself is the id group. The digits are matched greedily so nothing after them needs
+// checking, and not consuming it lets "US1 US2" find both. Longer prefixes come first so DE123 is not read as D
+// followed by E123.
+func artifactPattern(types map[string]string) string {
+	prefixes := make([]string, 0, len(types))
+	for p := range types {
+		prefixes = append(prefixes, regexp.QuoteMeta(p))
+	}
+
+	sort.Slice(prefixes, func(i, j int) bool {
+		if len(prefixes[i]) != len(prefixes[j]) {
+			return len(prefixes[i]) > len(prefixes[j])
+		}
+		return prefixes[i] < prefixes[j]
+	})
+
+	return `(?:^|[^A-Za-z0-9])(?P<id>(?P<prefix>` + strings.Join(prefixes, "|") + `)\d+)`
+}
+
+// artifactID - a formatted id found in text and the WSAPI type it is looked up as
+type artifactID struct {
+	FormattedID string
+	Type        string
+}
+
+// find - returns the formatted ids in text in the order they first appear, ids with an unknown prefix are skipped
+func (m *artifactMatcher) find(text string) []artifactID {
+	var (
+		ids  []artifactID
+		seen = make(map[string]bool)
+	)
+
+	for _, v := range m.regex.FindAllStringSubmatch(text, -1) {
+		id := v[m.id]
+		artifactType, ok := m.types[v[m.prefix]]
+		if !ok || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, artifactID{FormattedID: id, Type: artifactType})
+	}
+
+	return ids
+}
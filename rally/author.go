@@ -0,0 +1,157 @@
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rally
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Steps an author resolver can be configured with
+const (
+	ResolveMapping   = "mapping"
+	ResolveUsername  = "username"
+	ResolveEmail     = "email"
+	ResolveCommitter = "committer"
+)
+
+var defaultResolvers = []string{ResolveMapping, ResolveUsername, ResolveEmail, ResolveCommitter}
+
+// AuthorCfg - struct
+type AuthorCfg struct {
+	// MapFile is a json object of GitHub login or email to rally UserName
+	MapFile string `json:"map_file"`
+	// Resolvers are tried in order until one finds a rally user
+	Resolvers []string `json:"resolvers"`
+}
+
+// AuthorResolver - finds the rally user for the author of a commit
+type AuthorResolver interface {
+	// Resolve returns the user ref and the step that found it, both empty if no user was found
+	Resolve(ctx context.Context, c Commit) (ref string, source string)
+}
+
+// UserLookup - returns the ref of the rally user matching a WSAPI query, empty if there is not exactly one
+type UserLookup func(ctx context.Context, query string) string
+
+type identity struct {
+	Username string
+	Email    string
+}
+
+type chainResolver struct {
+	steps   []string
+	mapping map[string]string
+	lookup  UserLookup
+}
+
+// NewAuthorResolver - builds a resolver trying each configured step in turn:
+// mapping looks up the author's login then email in the map file, username and email query rally by UserName and EmailAddress,
+// committer repeats the other steps for the committer.
+func NewAuthorResolver(cfg AuthorCfg, lookup UserLookup) (AuthorResolver, error) {
+	r := &chainResolver{
+		steps:   cfg.Resolvers,
+		mapping: make(map[string]string),
+		lookup:  lookup,
+	}
+
+	if len(r.steps) == 0 {
+		r.steps = defaultResolvers
+	}
+
+	for _, step := range r.steps {
+		switch step {
+		case ResolveMapping, ResolveUsername, ResolveEmail, ResolveCommitter:
+		default:
+			return nil, fmt.Errorf("unknown author resolver %s", step)
+		}
+	}
+
+	if cfg.MapFile != "" {
+		b, err := ioutil.ReadFile(cfg.MapFile)
+		if err != nil {
+			return nil, err
+		}
+
+		var mapping map[string]string
+		if err = json.Unmarshal(b, &mapping); err != nil {
+			return nil, fmt.Errorf("invalid author map %s: %s", cfg.MapFile, err.Error())
+		}
+
+		// GitHub logins and emails are case insensitive
+		for k, v := range mapping {
+			r.mapping[strings.ToLower(k)] = v
+		}
+	}
+
+	return r, nil
+}
+
+func (r *chainResolver) Resolve(ctx context.Context, c Commit) (string, string) {
+	author := identity{Username: c.Author.Username, Email: c.Author.Email}
+	committer := identity{Username: c.Committer.Username, Email: c.Committer.Email}
+
+	for _, step := range r.steps {
+		if step == ResolveCommitter {
+			if committer == author {
+				continue
+			}
+			for _, s := range r.steps {
+				if s == ResolveCommitter {
+					continue
+				}
+				if ref := r.resolve(ctx, s, committer); ref != "" {
+					return ref, ResolveCommitter + "-" + s
+				}
+			}
+			continue
+		}
+
+		if ref := r.resolve(ctx, step, author); ref != "" {
+			return ref, step
+		}
+	}
+
+	return "", ""
+}
+
+func (r *chainResolver) resolve(ctx context.Context, step string, id identity) string {
+	switch step {
+	case ResolveMapping:
+		for _, key := range []string{id.Username, id.Email} {
+			if key == "" {
+				continue
+			}
+			if userName, ok := r.mapping[strings.ToLower(key)]; ok {
+				return r.lookup(ctx, fmt.Sprintf("(UserName = %s)", userName))
+			}
+		}
+	case ResolveUsername:
+		if id.Email != "" {
+			return r.lookup(ctx, fmt.Sprintf("(UserName = %s)", id.Email))
+		}
+	case ResolveEmail:
+		if id.Email != "" {
+			return r.lookup(ctx, fmt.Sprintf("(EmailAddress = %s)", id.Email))
+		}
+	}
+	return ""
+}
@@ -0,0 +1,119 @@
+// +build unit
+
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rally_test
+
+import (
+	"context"
+	"github.com/comcast/github-rally-hook/rally"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+)
+
+var _ = Describe("Resolving commit authors to rally users", func() {
+	var (
+		commit  rally.Commit
+		queries []string
+		users   map[string]string
+		lookup  rally.UserLookup
+	)
+
+	BeforeEach(func() {
+		commit = rally.Commit{ID: "39820cb3e629a2e18d3f7bea03effd785904336e"}
+		commit.Author.Email = "12345+octocat@users.noreply.github.com"
+		commit.Author.Username = "octocat"
+		commit.Committer.Email = "octo.cat@somecompany.com"
+		commit.Committer.Username = "octocat"
+
+		queries = nil
+		users = map[string]string{}
+		lookup = func(ctx context.Context, query string) string {
+			queries = append(queries, query)
+			return users[query]
+		}
+	})
+
+	Context("when the author's login is in the map file", func() {
+		var mapFile string
+
+		BeforeEach(func() {
+			f, err := ioutil.TempFile("", "authors")
+			Expect(err).ShouldNot(HaveOccurred())
+			f.WriteString(`{"OctoCat": "octo.cat@somecompany.com"}`)
+			f.Close()
+			mapFile = f.Name()
+
+			users["(UserName = octo.cat@somecompany.com)"] = "/user/1"
+		})
+
+		AfterEach(func() {
+			os.Remove(mapFile)
+		})
+
+		It("should look up the mapped rally user", func() {
+			resolver, err := rally.NewAuthorResolver(rally.AuthorCfg{MapFile: mapFile}, lookup)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			ref, source := resolver.Resolve(context.Background(), commit)
+			Expect(ref).Should(Equal("/user/1"))
+			Expect(source).Should(Equal(rally.ResolveMapping))
+			Expect(queries).Should(Equal([]string{"(UserName = octo.cat@somecompany.com)"}))
+		})
+	})
+
+	Context("when only the committer's email is known to rally", func() {
+		BeforeEach(func() {
+			users["(EmailAddress = octo.cat@somecompany.com)"] = "/user/2"
+		})
+
+		It("should fall back through the chain to the committer", func() {
+			resolver, err := rally.NewAuthorResolver(rally.AuthorCfg{}, lookup)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			ref, source := resolver.Resolve(context.Background(), commit)
+			Expect(ref).Should(Equal("/user/2"))
+			Expect(source).Should(Equal("committer-email"))
+			Expect(queries).Should(Equal([]string{
+				"(UserName = 12345+octocat@users.noreply.github.com)",
+				"(EmailAddress = 12345+octocat@users.noreply.github.com)",
+				"(UserName = octo.cat@somecompany.com)",
+				"(EmailAddress = octo.cat@somecompany.com)",
+			}))
+		})
+
+		It("should only try the configured resolvers", func() {
+			resolver, err := rally.NewAuthorResolver(rally.AuthorCfg{Resolvers: []string{rally.ResolveUsername}}, lookup)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			ref, source := resolver.Resolve(context.Background(), commit)
+			Expect(ref).Should(BeEmpty())
+			Expect(source).Should(BeEmpty())
+			Expect(queries).Should(HaveLen(1))
+		})
+	})
+
+	Context("when configured with an unknown resolver", func() {
+		It("should return an error", func() {
+			_, err := rally.NewAuthorResolver(rally.AuthorCfg{Resolvers: []string{"ldap"}}, lookup)
+			Expect(err).Should(HaveOccurred())
+		})
+	})
+})
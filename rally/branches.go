@@ -0,0 +1,73 @@
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rally
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+const branchRefPrefix = "refs/heads/"
+
+// BranchCfg - globs selecting the branches whose pushes are written to rally, e.g. "main" or "release/*".
+// When Allow is set a branch must match one of its globs, and it must not match any of Deny.
+type BranchCfg struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// validate - checks every glob is well formed
+func (b BranchCfg) validate() error {
+	for _, p := range append(append([]string{}, b.Allow...), b.Deny...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("branch %q: %s", p, err.Error())
+		}
+	}
+	return nil
+}
+
+// empty - true when no branches are configured so every branch is allowed
+func (b BranchCfg) empty() bool {
+	return len(b.Allow) == 0 && len(b.Deny) == 0
+}
+
+// allows - whether pushes to branch are written to rally
+func (b BranchCfg) allows(branch string) bool {
+	if len(b.Allow) > 0 && !matchAny(b.Allow, branch) {
+		return false
+	}
+	return !matchAny(b.Deny, branch)
+}
+
+// branchName - the full name of the branch a ref points to, e.g. "feature/foo" for "refs/heads/feature/foo". ok is false for tags and other refs.
+func branchName(ref string) (branch string, ok bool) {
+	if !strings.HasPrefix(ref, branchRefPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(ref, branchRefPrefix), true
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
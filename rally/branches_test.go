@@ -0,0 +1,194 @@
+// +build unit
+
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rally_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/comcast/github-rally-hook/rally"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"io/ioutil"
+	"net/http"
+)
+
+var _ = Describe("Filtering pushes by branch", func() {
+	var (
+		server    *ghttp.Server
+		svc       rally.Service
+		svcErr    error
+		pushEvent rally.PushEvent
+		cfg       rally.Config
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		server.AllowUnhandledRequests = false
+
+		pushReq, err := ioutil.ReadFile("../fixtures/sample_pushevent.json")
+		if err != nil {
+			Skip(err.Error())
+		}
+
+		err = json.NewDecoder(bytes.NewReader(pushReq)).Decode(&pushEvent)
+		if err != nil {
+			Skip(err.Error())
+		}
+
+		cfg = rally.Config{
+			RallyURL:  server.URL(),
+			APIToken:  "1234abcde",
+			Workspace: "Comcast",
+			Branches: rally.BranchCfg{
+				Allow: []string{"main", "release/*"},
+				Deny:  []string{"release/*-rc"},
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		svc, svcErr = rally.NewPushReceiveService(log.NewNopLogger(), cfg)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Context("when a tag is pushed", func() {
+		It("should ignore the push", func() {
+			pushEvent.Ref = "refs/tags/v1.0.0"
+
+			Expect(svcErr).ShouldNot(HaveOccurred())
+
+			response, err := svc.ReceivePush(context.Background(), pushEvent)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(response.Result).Should(Equal("ignored"))
+			Consistently(server.ReceivedRequests).Should(BeEmpty())
+		})
+	})
+
+	Context("when a branch that is not allowed is pushed", func() {
+		It("should ignore the push", func() {
+			pushEvent.Ref = "refs/heads/feature/US12345"
+
+			Expect(svcErr).ShouldNot(HaveOccurred())
+
+			response, err := svc.ReceivePush(context.Background(), pushEvent)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(response.Result).Should(Equal("ignored"))
+			Consistently(server.ReceivedRequests).Should(BeEmpty())
+		})
+	})
+
+	Context("when an allowed branch matches a denied glob", func() {
+		It("should ignore the push", func() {
+			pushEvent.Ref = "refs/heads/release/2.0-rc"
+
+			Expect(svcErr).ShouldNot(HaveOccurred())
+
+			response, err := svc.ReceivePush(context.Background(), pushEvent)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(response.Result).Should(Equal("ignored"))
+			Consistently(server.ReceivedRequests).Should(BeEmpty())
+		})
+	})
+
+	Context("when an allowed branch is pushed", func() {
+		BeforeEach(func() {
+			w, err := ioutil.ReadFile("../fixtures/success_getWorkspace.json")
+			if err != nil {
+				Skip(err.Error())
+			}
+
+			gs, err := ioutil.ReadFile("../fixtures/success_getSCMRepo.json")
+			if err != nil {
+				Skip(err.Error())
+			}
+
+			u, err := ioutil.ReadFile("../fixtures/success_getUser.json")
+			if err != nil {
+				Skip(err.Error())
+			}
+
+			us, err := ioutil.ReadFile("../fixtures/success_getUserStory.json")
+			if err != nil {
+				Skip(err.Error())
+			}
+
+			gcs, err := ioutil.ReadFile("../fixtures/success_getChangeSet_none.json")
+			if err != nil {
+				Skip(err.Error())
+			}
+
+			chset, err := ioutil.ReadFile("../fixtures/success_createChangeSet.json")
+			if err != nil {
+				Skip(err.Error())
+			}
+
+			ch, err := ioutil.ReadFile("../fixtures/success_createChange.json")
+			if err != nil {
+				Skip(err.Error())
+			}
+
+			server.AppendHandlers(
+				ghttp.RespondWith(http.StatusOK, string(w[:])),
+				ghttp.RespondWith(http.StatusOK, string(gs[:])),
+				ghttp.RespondWith(http.StatusOK, string(us[:])),
+				ghttp.RespondWith(http.StatusOK, string(u[:])),
+				ghttp.RespondWith(http.StatusOK, string(gcs[:])),
+				// The full branch name is recorded on the changeset
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/changeset/create"),
+					func(w http.ResponseWriter, r *http.Request) {
+						var body map[string]map[string]interface{}
+						Expect(json.NewDecoder(r.Body).Decode(&body)).Should(Succeed())
+						Expect(body["Changeset"]["Branch"]).Should(Equal("release/1.2"))
+					},
+					ghttp.RespondWith(http.StatusOK, string(chset[:])),
+				),
+				ghttp.RespondWith(http.StatusOK, string(ch[:])),
+			)
+		})
+
+		It("should write the push to rally", func() {
+			pushEvent.Ref = "refs/heads/release/1.2"
+
+			Expect(svcErr).ShouldNot(HaveOccurred())
+
+			response, err := svc.ReceivePush(context.Background(), pushEvent)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(response.Result).Should(Equal("created"))
+			Eventually(server.ReceivedRequests).Should(HaveLen(7))
+		})
+	})
+
+	Context("when a branch glob is invalid", func() {
+		BeforeEach(func() {
+			cfg.Branches.Allow = []string{"release/["}
+		})
+
+		It("should fail to create the service", func() {
+			Expect(svcErr).Should(HaveOccurred())
+		})
+	})
+})
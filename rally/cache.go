@@ -0,0 +1,138 @@
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rally
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultCacheTTL         = time.Hour
+	defaultCacheNegativeTTL = 5 * time.Minute
+	cacheSweepSize          = 10000
+
+	// Artifacts are cached briefly, long enough to cover the commits of a busy period without hiding a renumbered artifact for long
+	defaultArtifactCacheTTL         = 5 * time.Minute
+	defaultArtifactCacheNegativeTTL = time.Minute
+)
+
+// CacheCfg - struct
+type CacheCfg struct {
+	TTLSeconds         int `json:"ttl_seconds"`
+	NegativeTTLSeconds int `json:"negative_ttl_seconds"`
+}
+
+// CacheStats - hit and miss counts of a named cache
+type CacheStats struct {
+	Name   string `json:"name"`
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+}
+
+// ttlCache - concurrency safe cache of string values. An empty value records a negative result and expires after the shorter negative ttl
+// so a user created in rally since the last lookup is picked up.
+type ttlCache struct {
+	name        string
+	ttl         time.Duration
+	negativeTTL time.Duration
+	mut         sync.Mutex
+	entries     map[string]cacheEntry
+	hits        uint64
+	misses      uint64
+}
+
+type cacheEntry struct {
+	value   string
+	expires time.Time
+}
+
+func newTTLCache(name string, cfg CacheCfg, ttl time.Duration, negativeTTL time.Duration) *ttlCache {
+	if cfg.TTLSeconds > 0 {
+		ttl = time.Duration(cfg.TTLSeconds) * time.Second
+	}
+	if cfg.NegativeTTLSeconds > 0 {
+		negativeTTL = time.Duration(cfg.NegativeTTLSeconds) * time.Second
+	}
+
+	return &ttlCache{
+		name:        name,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		entries:     make(map[string]cacheEntry),
+	}
+}
+
+// Get - returns the cached value, ok is false if there is none or it has expired
+func (c *ttlCache) Get(key string) (value string, ok bool) {
+	c.mut.Lock()
+	entry, ok := c.entries[key]
+	if ok && time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		ok = false
+	}
+	c.mut.Unlock()
+
+	if !ok {
+		atomic.AddUint64(&c.misses, 1)
+		return "", false
+	}
+
+	atomic.AddUint64(&c.hits, 1)
+	return entry.value, true
+}
+
+// Set - caches value, an empty value is cached for the negative ttl
+func (c *ttlCache) Set(key string, value string) {
+	ttl := c.ttl
+	if value == "" {
+		ttl = c.negativeTTL
+	}
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if len(c.entries) >= cacheSweepSize {
+		c.sweep()
+	}
+
+	c.entries[key] = cacheEntry{
+		value:   value,
+		expires: time.Now().Add(ttl),
+	}
+}
+
+// sweep - drops expired entries, called with the lock held
+func (c *ttlCache) sweep() {
+	now := time.Now()
+	for k, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// Stats - hit and miss counts since the cache was created
+func (c *ttlCache) Stats() CacheStats {
+	return CacheStats{
+		Name:   c.name,
+		Hits:   atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+	}
+}
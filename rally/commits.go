@@ -0,0 +1,170 @@
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rally
+
+import (
+	"context"
+	"github.com/comcast/github-rally-hook/github"
+	"github.com/go-kit/kit/log"
+	"strings"
+)
+
+const (
+	// maxPayloadCommits - GitHub leaves commits out of a push payload after this many
+	maxPayloadCommits = 20
+	// maxPayloadFiles - GitHub leaves files out of a commit in a push payload after this many added, modified and removed together
+	maxPayloadFiles = 3000
+)
+
+// pushCommits - the commits of a push. When the payload may have been truncated the full list is read from the GitHub compare api,
+// commits missing from the payload, or whose files may have been cut short, are read one at a time for their files.
+// A new branch is compared with the repository's default branch. The payload is used as it is if GitHub can't be reached.
+func (s *service) pushCommits(ctx context.Context, event PushEvent, logger log.Logger) []Commit {
+	if len(event.Commits) < maxPayloadCommits {
+		return s.fullCommits(ctx, event, event.Commits, logger)
+	}
+
+	repo := event.Repository.FullName
+	if s.github == nil {
+		logger.Log("status", "push may be truncated", "commits", len(event.Commits), "reason", "no github credentials")
+		return s.fullCommits(ctx, event, event.Commits, logger)
+	}
+
+	base := event.Before
+	if zeroRevision(base) {
+		base = event.Repository.DefaultBranch
+		if base == "" || event.Ref == "refs/heads/"+base {
+			logger.Log("status", "push may be truncated", "commits", len(event.Commits), "reason", "new branch with nothing to compare with")
+			return s.fullCommits(ctx, event, event.Commits, logger)
+		}
+	}
+
+	comparison, err := s.github.Compare(ctx, repo, base, event.After, event.Installation.ID)
+	if err != nil {
+		logger.Log("Compare", repo, "err", err.Error())
+		return s.fullCommits(ctx, event, event.Commits, logger)
+	}
+
+	if comparison.TotalCommits <= len(event.Commits) {
+		return s.fullCommits(ctx, event, event.Commits, logger)
+	}
+
+	received := make(map[string]Commit, len(event.Commits))
+	for _, c := range event.Commits {
+		received[c.ID] = c
+	}
+
+	commits := make([]Commit, 0, len(comparison.Commits))
+	for _, gc := range comparison.Commits {
+		if c, ok := received[gc.SHA]; ok && !filesTruncated(c) {
+			commits = append(commits, c)
+			continue
+		}
+
+		// Still record a commit that can't be read, only its changes are lost
+		fallback, ok := received[gc.SHA]
+		if !ok {
+			fallback = commitFromGitHub(gc)
+		}
+		commits = append(commits, s.readCommit(ctx, repo, gc.SHA, event.Installation.ID, fallback, logger))
+	}
+
+	logger.Log("status", "expanded truncated push", "received", len(event.Commits), "commits", len(commits))
+
+	return commits
+}
+
+// fullCommits - the commits with any whose files may have been cut short read again from GitHub
+func (s *service) fullCommits(ctx context.Context, event PushEvent, commits []Commit, logger log.Logger) []Commit {
+	full := make([]Commit, len(commits))
+	for i, c := range commits {
+		full[i] = c
+		if !filesTruncated(c) {
+			continue
+		}
+
+		if s.github == nil {
+			logger.Log("status", "commit files may be truncated", "commit", c.ID, "files", maxPayloadFiles, "reason", "no github credentials")
+			continue
+		}
+
+		full[i] = s.readCommit(ctx, event.Repository.FullName, c.ID, event.Installation.ID, c, logger)
+	}
+
+	return full
+}
+
+// readCommit - the commit with all of its files from GitHub, fallback when it can't be read
+func (s *service) readCommit(ctx context.Context, repo string, sha string, installationID int64, fallback Commit, logger log.Logger) Commit {
+	full, err := s.github.GetCommit(ctx, repo, sha, installationID)
+	if err != nil {
+		logger.Log("GetCommit", sha, "err", err.Error())
+		return fallback
+	}
+	return commitFromGitHub(*full)
+}
+
+// filesTruncated - whether GitHub may have left files out of a commit in a push payload
+func filesTruncated(c Commit) bool {
+	return len(c.Added)+len(c.Modified)+len(c.Removed) >= maxPayloadFiles
+}
+
+// commitFromGitHub - converts a commit read from the GitHub api to the form it takes in a push payload. A renamed file is recorded as
+// the old file removed and the new file added.
+func commitFromGitHub(gc github.Commit) Commit {
+	c := Commit{
+		ID:        gc.SHA,
+		TreeID:    gc.Commit.Tree.SHA,
+		Distinct:  true,
+		Message:   gc.Commit.Message,
+		Timestamp: gc.Commit.Committer.Date,
+		URL:       gc.HTMLURL,
+	}
+
+	c.Author.Name = gc.Commit.Author.Name
+	c.Author.Email = gc.Commit.Author.Email
+	if gc.Author != nil {
+		c.Author.Username = gc.Author.Login
+	}
+	c.Committer.Name = gc.Commit.Committer.Name
+	c.Committer.Email = gc.Commit.Committer.Email
+	if gc.Committer != nil {
+		c.Committer.Username = gc.Committer.Login
+	}
+
+	for _, f := range gc.Files {
+		switch f.Status {
+		case "added", "copied":
+			c.Added = append(c.Added, f.Filename)
+		case "removed":
+			c.Removed = append(c.Removed, f.Filename)
+		case "renamed":
+			c.Removed = append(c.Removed, f.PreviousFilename)
+			c.Added = append(c.Added, f.Filename)
+		default:
+			c.Modified = append(c.Modified, f.Filename)
+		}
+	}
+
+	return c
+}
+
+// zeroRevision - GitHub sends a revision of all zeros as Before when a branch is created
+func zeroRevision(revision string) bool {
+	return strings.Trim(revision, "0") == ""
+}
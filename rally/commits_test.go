@@ -0,0 +1,203 @@
+// +build unit
+
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rally_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/comcast/github-rally-hook/github"
+	"github.com/comcast/github-rally-hook/rally"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+var _ = Describe("Expanding truncated pushes", func() {
+	var (
+		server    *ghttp.Server
+		gh        *ghttp.Server
+		svc       rally.Service
+		pushEvent rally.PushEvent
+
+		mut     sync.Mutex
+		changes []string
+
+		// base - the revision the push is compared with
+		base       string
+		missing    string
+		comparison github.Comparison
+		getCommit  []byte
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		server.AllowUnhandledRequests = false
+		gh = ghttp.NewServer()
+		gh.AllowUnhandledRequests = false
+
+		fixtures := map[string][]byte{}
+		for _, f := range []string{"success_getWorkspace", "success_getSCMRepo", "success_getUserStory", "success_getUser",
+			"success_getChangeSet_none", "success_createChangeSet", "success_createChange", "sample_pushevent", "github_getCommit"} {
+			b, err := ioutil.ReadFile("../fixtures/" + f + ".json")
+			if err != nil {
+				Skip(err.Error())
+			}
+			fixtures[f] = b
+		}
+
+		err := json.NewDecoder(bytes.NewReader(fixtures["sample_pushevent"])).Decode(&pushEvent)
+		if err != nil {
+			Skip(err.Error())
+		}
+
+		// A push of 21 commits, GitHub only sends the last 20
+		missing = "6dcb09b5b57875f334f61aebed695e2e4193db5e"
+		comparison = github.Comparison{TotalCommits: 21}
+		comparison.Commits = append(comparison.Commits, github.Commit{SHA: missing})
+
+		commit := pushEvent.Commits[0]
+		pushEvent.Commits = nil
+		for i := 1; i <= 20; i++ {
+			commit.ID = fmt.Sprintf("%040d", i)
+			pushEvent.Commits = append(pushEvent.Commits, commit)
+			comparison.Commits = append(comparison.Commits, github.Commit{SHA: commit.ID})
+		}
+		pushEvent.After = commit.ID
+		base = pushEvent.Before
+		getCommit = fixtures["github_getCommit"]
+
+		changes = nil
+		server.RouteToHandler("GET", "/slm/webservice/v2.0/workspace", ghttp.RespondWith(http.StatusOK, string(fixtures["success_getWorkspace"])))
+		server.RouteToHandler("GET", "/slm/webservice/v2.0/scmrepository", ghttp.RespondWith(http.StatusOK, string(fixtures["success_getSCMRepo"])))
+		server.RouteToHandler("GET", "/slm/webservice/v2.0/hierarchicalrequirement", ghttp.RespondWith(http.StatusOK, string(fixtures["success_getUserStory"])))
+		server.RouteToHandler("GET", "/slm/webservice/v2.0/user", ghttp.RespondWith(http.StatusOK, string(fixtures["success_getUser"])))
+		server.RouteToHandler("GET", "/slm/webservice/v2.0/changeset", ghttp.RespondWith(http.StatusOK, string(fixtures["success_getChangeSet_none"])))
+		server.RouteToHandler("POST", "/slm/webservice/v2.0/changeset/create", ghttp.RespondWith(http.StatusOK, string(fixtures["success_createChangeSet"])))
+		server.RouteToHandler("POST", "/slm/webservice/v2.0/change/create", ghttp.CombineHandlers(
+			func(w http.ResponseWriter, r *http.Request) {
+				var body map[string]map[string]string
+				Expect(json.NewDecoder(r.Body).Decode(&body)).Should(Succeed())
+
+				mut.Lock()
+				changes = append(changes, body["Change"]["Action"]+" "+body["Change"]["PathAndFilename"])
+				mut.Unlock()
+			},
+			ghttp.RespondWith(http.StatusOK, string(fixtures["success_createChange"])),
+		))
+
+		svc, err = rally.NewPushReceiveService(log.NewNopLogger(), rally.Config{
+			RallyURL:  server.URL(),
+			APIToken:  "1234abcde",
+			Workspace: "Comcast",
+			Workers:   1,
+			GitHub: github.Config{
+				URL:   gh.URL(),
+				Token: "gh-token",
+			},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	JustBeforeEach(func() {
+		gh.AppendHandlers(
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", fmt.Sprintf("/repos/ABC/data-service/compare/%s...%s", base, pushEvent.After)),
+				ghttp.VerifyHeader(http.Header{"Authorization": []string{"token gh-token"}}),
+				ghttp.RespondWithJSONEncoded(http.StatusOK, comparison),
+			),
+			ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/repos/ABC/data-service/commits/"+missing),
+				ghttp.RespondWith(http.StatusOK, string(getCommit)),
+			),
+		)
+	})
+
+	AfterEach(func() {
+		server.Close()
+		gh.Close()
+	})
+
+	recorded := func() []string {
+		mut.Lock()
+		defer mut.Unlock()
+		return append([]string{}, changes...)
+	}
+
+	It("should write a changeset for every commit in the push", func() {
+		_, err := svc.ReceivePush(context.Background(), pushEvent)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		// 20 changes from the payload and the missing commit's added file and rename
+		Eventually(recorded).Should(HaveLen(23))
+		Expect(recorded()).Should(ContainElement("A scheduler/scheduler.go"))
+		Expect(recorded()).Should(ContainElement("R config/jobs.go"))
+		Expect(recorded()).Should(ContainElement("A scheduler/jobs.go"))
+		Expect(gh.ReceivedRequests()).Should(HaveLen(2))
+	})
+
+	Context("when the push creates the branch", func() {
+		BeforeEach(func() {
+			pushEvent.Before = "0000000000000000000000000000000000000000"
+			base = pushEvent.Repository.DefaultBranch
+		})
+
+		It("should compare the branch with the default branch to find every commit", func() {
+			_, err := svc.ReceivePush(context.Background(), pushEvent)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Eventually(recorded).Should(HaveLen(23))
+			Expect(recorded()).Should(ContainElement("A scheduler/scheduler.go"))
+			Expect(gh.ReceivedRequests()).Should(HaveLen(2))
+		})
+	})
+
+	Context("when a commit's files were cut short", func() {
+		BeforeEach(func() {
+			commit := pushEvent.Commits[0]
+			commit.Added = nil
+			commit.Modified = nil
+			commit.Removed = nil
+			for i := 0; i < 3000; i++ {
+				commit.Added = append(commit.Added, fmt.Sprintf("generated/file%04d.go", i))
+			}
+			pushEvent.Commits = []rally.Commit{commit}
+			pushEvent.After = commit.ID
+
+			gh.RouteToHandler("GET", "/repos/ABC/data-service/commits/"+commit.ID, ghttp.RespondWith(http.StatusOK, string(getCommit)))
+		})
+
+		It("should read the commit's files from GitHub", func() {
+			_, err := svc.ReceivePush(context.Background(), pushEvent)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			// The files of the commit as GitHub has them, an added file and a rename
+			Eventually(recorded).Should(HaveLen(3))
+			Consistently(recorded).Should(HaveLen(3))
+			Expect(recorded()).Should(ContainElement("A scheduler/scheduler.go"))
+			Expect(gh.ReceivedRequests()).Should(HaveLen(1))
+		})
+	})
+})
@@ -0,0 +1,200 @@
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rally
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/comcast/github-rally-hook/rally/wsapi"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Operations recorded against a dead letter
+const (
+	OpGetOrCreateSCMRepository = "GetOrCreateSCMRepository"
+	OpAddChangeSet             = "AddChangeSet"
+	OpAddChange                = "AddChange"
+	OpUpdateState              = "UpdateState"
+	OpAddConversationPost      = "AddConversationPost"
+)
+
+// DeadLetter - a rally operation that failed after all retries, with enough detail to run it again
+type DeadLetter struct {
+	ID           string      `json:"id"`
+	Operation    string      `json:"operation"`
+	Error        string      `json:"error"`
+	Created      time.Time   `json:"created"`
+	Attempts     int         `json:"attempts"`
+	Repo         string      `json:"repo"`
+	FullName     string      `json:"full_name,omitempty"`
+	RepoURL      string      `json:"repo_url"`
+	Branch       string      `json:"branch"`
+	WorkspaceRef string      `json:"workspace_ref"`
+	ProjectRef   string      `json:"project_ref,omitempty"`
+	SCMRepo      string      `json:"scm_repo,omitempty"`
+	Commit       Commit      `json:"commit"`
+	Changeset    string      `json:"changeset,omitempty"`
+	Action       string      `json:"action,omitempty"`
+	Path         string      `json:"path,omitempty"`
+	URI          string      `json:"uri,omitempty"`
+	Artifact     string      `json:"artifact,omitempty"`
+	Fields       FieldValues `json:"fields,omitempty"`
+	Text         string      `json:"text,omitempty"`
+	// RallyError is why Rally refused the operation, when it did
+	RallyError *wsapi.RallyError `json:"rally_error,omitempty"`
+}
+
+// DeadLetterStore - dead letters kept one file each in dir, or in memory only when dir is empty
+type DeadLetterStore struct {
+	dir     string
+	mut     sync.Mutex
+	seq     int
+	letters map[string]DeadLetter
+}
+
+// NewDeadLetterStore - opens the store in dir and loads any existing dead letters
+func NewDeadLetterStore(dir string) (*DeadLetterStore, error) {
+	d := &DeadLetterStore{
+		dir:     dir,
+		letters: make(map[string]DeadLetter),
+	}
+
+	if dir == "" {
+		return d, nil
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		var letter DeadLetter
+		if err = json.Unmarshal(b, &letter); err != nil {
+			return nil, fmt.Errorf("corrupt dead letter %s: %s", f, err.Error())
+		}
+		d.letters[letter.ID] = letter
+	}
+
+	return d, nil
+}
+
+// Add - records a new dead letter
+func (d *DeadLetterStore) Add(letter DeadLetter) (DeadLetter, error) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	d.seq++
+	letter.ID = fmt.Sprintf("%020d-%06d", time.Now().UnixNano(), d.seq)
+	letter.Created = time.Now().UTC()
+	letter.Attempts = 1
+
+	if err := d.write(letter); err != nil {
+		return DeadLetter{}, err
+	}
+	d.letters[letter.ID] = letter
+
+	return letter, nil
+}
+
+// Update - replaces an existing dead letter, used to record the outcome of a failed re-drive
+func (d *DeadLetterStore) Update(letter DeadLetter) error {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	if _, ok := d.letters[letter.ID]; !ok {
+		return ErrNotFound
+	}
+
+	if err := d.write(letter); err != nil {
+		return err
+	}
+	d.letters[letter.ID] = letter
+
+	return nil
+}
+
+// Get - returns the dead letter with the given id
+func (d *DeadLetterStore) Get(id string) (DeadLetter, bool) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	letter, ok := d.letters[id]
+	return letter, ok
+}
+
+// List - returns all dead letters, oldest first
+func (d *DeadLetterStore) List() []DeadLetter {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	letters := make([]DeadLetter, 0, len(d.letters))
+	for _, l := range d.letters {
+		letters = append(letters, l)
+	}
+
+	sort.Slice(letters, func(i, j int) bool {
+		return letters[i].ID < letters[j].ID
+	})
+
+	return letters
+}
+
+// Remove - deletes a dead letter once it has been re-driven successfully
+func (d *DeadLetterStore) Remove(id string) error {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	if _, ok := d.letters[id]; !ok {
+		return ErrNotFound
+	}
+
+	if d.dir != "" {
+		if err := os.Remove(d.path(id)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	delete(d.letters, id)
+
+	return nil
+}
+
+func (d *DeadLetterStore) write(letter DeadLetter) error {
+	if d.dir == "" {
+		return nil
+	}
+	return writeFileAtomic(d.path(letter.ID), letter)
+}
+
+func (d *DeadLetterStore) path(id string) string {
+	return filepath.Join(d.dir, id+".json")
+}
@@ -0,0 +1,421 @@
+// +build unit
+
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rally_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/comcast/github-rally-hook/rally"
+	"github.com/comcast/github-rally-hook/rally/wsapi"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+)
+
+// roundTripperFunc - a transport answering with f, so failures that can't be caused with a server can be
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+var _ = Describe("Retrying and dead lettering rally calls", func() {
+	var server *ghttp.Server
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		server.AllowUnhandledRequests = false
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("NewRetryTransport", func() {
+		var client *http.Client
+
+		BeforeEach(func() {
+			client = &http.Client{
+				Transport: rally.NewRetryTransport(http.DefaultTransport, rally.RetryCfg{
+					Attempts:         3,
+					InitialBackoffMs: 1,
+					MaxBackoffMs:     5,
+				}),
+			}
+		})
+
+		Context("when rally is throttling and then recovers", func() {
+			BeforeEach(func() {
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/change/create"),
+						ghttp.VerifyBody([]byte(`{"Change":{}}`)),
+						ghttp.RespondWith(http.StatusTooManyRequests, "", http.Header{"Retry-After": []string{"0"}}),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/change/create"),
+						ghttp.VerifyBody([]byte(`{"Change":{}}`)),
+						ghttp.RespondWith(http.StatusServiceUnavailable, ""),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/change/create"),
+						ghttp.VerifyBody([]byte(`{"Change":{}}`)),
+						ghttp.RespondWith(http.StatusOK, "{}"),
+					),
+				)
+			})
+			It("should resend the request until it succeeds", func() {
+				response, err := client.Post(server.URL()+"/slm/webservice/v2.0/change/create", "application/json", bytes.NewBufferString(`{"Change":{}}`))
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(response.StatusCode).Should(Equal(http.StatusOK))
+				Expect(server.ReceivedRequests()).Should(HaveLen(3))
+			})
+		})
+
+		Context("when rally keeps failing", func() {
+			BeforeEach(func() {
+				for i := 0; i < 3; i++ {
+					server.AppendHandlers(ghttp.RespondWith(http.StatusServiceUnavailable, ""))
+				}
+			})
+			It("should give up after the configured attempts", func() {
+				response, err := client.Get(server.URL() + "/slm/webservice/v2.0/workspace")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(response.StatusCode).Should(Equal(http.StatusServiceUnavailable))
+				Expect(server.ReceivedRequests()).Should(HaveLen(3))
+			})
+		})
+		Context("when a create reaches rally but the gateway times out", func() {
+			BeforeEach(func() {
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/change/create"),
+						ghttp.RespondWith(http.StatusGatewayTimeout, ""),
+					),
+				)
+			})
+			It("should not send the create again, it may have been applied", func() {
+				response, err := client.Post(server.URL()+"/slm/webservice/v2.0/change/create", "application/json", bytes.NewBufferString(`{"Change":{}}`))
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(response.StatusCode).Should(Equal(http.StatusGatewayTimeout))
+				Expect(server.ReceivedRequests()).Should(HaveLen(1))
+			})
+		})
+
+		Context("when a create fails before a response", func() {
+			var (
+				sent []*http.Request
+				fail error
+			)
+
+			BeforeEach(func() {
+				sent = nil
+				client.Transport = rally.NewRetryTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+					sent = append(sent, req)
+					if len(sent) == 1 {
+						return nil, fail
+					}
+					return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(bytes.NewReader(nil))}, nil
+				}), rally.RetryCfg{Attempts: 3, InitialBackoffMs: 1, MaxBackoffMs: 5})
+			})
+
+			It("should send it again with a copy of the request when the connection could not be made", func() {
+				fail = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+				req, err := http.NewRequest("POST", server.URL()+"/slm/webservice/v2.0/change/create", bytes.NewBufferString(`{"Change":{}}`))
+				Expect(err).ShouldNot(HaveOccurred())
+				body := req.Body
+
+				response, err := client.Do(req)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(response.StatusCode).Should(Equal(http.StatusOK))
+				Expect(sent).Should(HaveLen(2))
+				Expect(sent[1]).ShouldNot(BeIdenticalTo(sent[0]))
+				Expect(req.Body).Should(BeIdenticalTo(body))
+			})
+
+			It("should not send it again when the connection was lost", func() {
+				fail = io.ErrUnexpectedEOF
+
+				_, err := client.Post(server.URL()+"/slm/webservice/v2.0/change/create", "application/json", bytes.NewBufferString(`{"Change":{}}`))
+				Expect(err).Should(HaveOccurred())
+				Expect(sent).Should(HaveLen(1))
+			})
+		})
+	})
+
+	Describe(".Redrive", func() {
+		var (
+			svc       rally.Service
+			pushEvent rally.PushEvent
+			ctx       context.Context
+			gcs       []byte
+		)
+
+		Context("when a changeset could not be created", func() {
+			BeforeEach(func() {
+				w, err := ioutil.ReadFile("../fixtures/success_getWorkspace.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				gs, err := ioutil.ReadFile("../fixtures/success_getSCMRepo.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				u, err := ioutil.ReadFile("../fixtures/success_getUser.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				us, err := ioutil.ReadFile("../fixtures/success_getUserStory.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				gcs, err = ioutil.ReadFile("../fixtures/success_getChangeSet_none.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				ups, err := ioutil.ReadFile("../fixtures/success_updateStateStarts.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				pushReq, err := ioutil.ReadFile("../fixtures/sample_pushevent.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				err = json.NewDecoder(bytes.NewReader(pushReq)).Decode(&pushEvent)
+				if err != nil {
+					Skip(err.Error())
+				}
+				pushEvent.Commits[0].Author.Email = "redrive@somecompany.com"
+				pushEvent.Commits[0].Message = "STARTS US12345 - misnamed CompletionPercentage"
+
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/workspace"),
+						ghttp.RespondWith(http.StatusOK, string(w[:])),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/scmrepository"),
+						ghttp.RespondWith(http.StatusOK, string(gs[:])),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/hierarchicalrequirement"),
+						ghttp.RespondWith(http.StatusOK, string(us[:])),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/user"),
+						ghttp.RespondWith(http.StatusOK, string(u[:])),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/changeset"),
+						ghttp.RespondWith(http.StatusOK, string(gcs[:])),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/hierarchicalrequirement/271167421104"),
+						ghttp.RespondWith(http.StatusOK, string(ups[:])),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/changeset/create"),
+						ghttp.RespondWith(http.StatusInternalServerError, "{}"),
+					),
+				)
+
+				ctx = context.Background()
+				svc, err = rally.NewPushReceiveService(log.NewNopLogger(), rally.Config{
+					RallyURL:  server.URL(),
+					APIToken:  "1234abcde",
+					Workspace: "Comcast",
+					Retry:     rally.RetryCfg{Attempts: 1},
+				})
+				Expect(err).ShouldNot(HaveOccurred())
+
+				_, err = svc.ReceivePush(ctx, pushEvent)
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+			It("should record the commit and create the changeset when re-driven without moving the story again", func() {
+				var letters []rally.DeadLetter
+				Eventually(func() []rally.DeadLetter {
+					letters, _ = svc.DeadLetters(ctx)
+					return letters
+				}).Should(HaveLen(1))
+
+				Expect(letters[0].Operation).Should(Equal(rally.OpAddChangeSet))
+				Expect(letters[0].Commit.ID).Should(Equal(pushEvent.Commits[0].ID))
+				Expect(letters[0].Repo).Should(Equal(pushEvent.Repository.Name))
+				Expect(letters[0].RallyError).ShouldNot(BeNil())
+				Expect(letters[0].RallyError.StatusCode).Should(Equal(http.StatusInternalServerError))
+				Expect(letters[0].RallyError.Operation).Should(Equal(wsapi.OpCreate))
+				Expect(letters[0].RallyError.ObjectType).Should(Equal("changeset"))
+
+				chset, err := ioutil.ReadFile("../fixtures/success_createChangeSet.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				ch, err := ioutil.ReadFile("../fixtures/success_createChange.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				// The story and user are still cached from the push, and the story was already moved when the push was processed
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/changeset"),
+						ghttp.RespondWith(http.StatusOK, string(gcs[:])),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/changeset/create"),
+						ghttp.RespondWith(http.StatusOK, string(chset[:])),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/change/create"),
+						ghttp.RespondWith(http.StatusOK, string(ch[:])),
+					),
+				)
+
+				Expect(svc.Redrive(ctx, letters[0].ID)).Should(Succeed())
+
+				letters, _ = svc.DeadLetters(ctx)
+				Expect(letters).Should(BeEmpty())
+			})
+		})
+
+		Context("when rally refuses a change", func() {
+			BeforeEach(func() {
+				w, err := ioutil.ReadFile("../fixtures/success_getWorkspace.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				gs, err := ioutil.ReadFile("../fixtures/success_getSCMRepo.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				gcs, err = ioutil.ReadFile("../fixtures/success_getChangeSet_none.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				chset, err := ioutil.ReadFile("../fixtures/success_createChangeSet.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				fu, err := ioutil.ReadFile("../fixtures/fail_getUser.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				pushReq, err := ioutil.ReadFile("../fixtures/sample_pushevent.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				err = json.NewDecoder(bytes.NewReader(pushReq)).Decode(&pushEvent)
+				if err != nil {
+					Skip(err.Error())
+				}
+				pushEvent.Commits[0].Message = "Fix a typo"
+				pushEvent.Commits[0].Added = []string{"README.md"}
+				pushEvent.Commits[0].Modified = nil
+				pushEvent.Commits[0].Removed = nil
+
+				// However the author is looked up they are not found
+				server.RouteToHandler("GET", "/slm/webservice/v2.0/user", ghttp.RespondWith(http.StatusOK, string(fu[:])))
+
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/workspace"),
+						ghttp.RespondWith(http.StatusOK, string(w[:])),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/scmrepository"),
+						ghttp.RespondWith(http.StatusOK, string(gs[:])),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/changeset"),
+						ghttp.RespondWith(http.StatusOK, string(gcs[:])),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/changeset/create"),
+						ghttp.RespondWith(http.StatusOK, string(chset[:])),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/change/create"),
+						ghttp.RespondWith(http.StatusOK, `{"CreateResult": {"Errors": ["Validation error: Change.Uri must be less than 256 characters"], "Warnings": []}}`),
+					),
+				)
+
+				ctx = context.Background()
+				svc, err = rally.NewPushReceiveService(log.NewNopLogger(), rally.Config{
+					RallyURL:  server.URL(),
+					APIToken:  "1234abcde",
+					Workspace: "Comcast",
+					Retry:     rally.RetryCfg{Attempts: 1},
+				})
+				Expect(err).ShouldNot(HaveOccurred())
+
+				_, err = svc.ReceivePush(ctx, pushEvent)
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+			It("should record why rally refused it", func() {
+				var letters []rally.DeadLetter
+				Eventually(func() []rally.DeadLetter {
+					letters, _ = svc.DeadLetters(ctx)
+					return letters
+				}).Should(HaveLen(1))
+
+				Expect(letters[0].Operation).Should(Equal(rally.OpAddChange))
+				Expect(letters[0].Path).Should(Equal("README.md"))
+				Expect(letters[0].Error).Should(ContainSubstring("Change.Uri must be less than 256 characters"))
+				Expect(letters[0].RallyError.Operation).Should(Equal(wsapi.OpCreate))
+				Expect(letters[0].RallyError.ObjectType).Should(Equal("change"))
+				Expect(letters[0].RallyError.Errors).Should(ConsistOf("Validation error: Change.Uri must be less than 256 characters"))
+			})
+		})
+
+		Context("when the dead letter does not exist", func() {
+			BeforeEach(func() {
+				var err error
+				ctx = context.Background()
+				svc, err = rally.NewPushReceiveService(log.NewNopLogger(), rally.Config{RallyURL: server.URL()})
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+			It("should return not found", func() {
+				Expect(svc.Redrive(ctx, "missing")).Should(Equal(rally.ErrNotFound))
+			})
+		})
+	})
+})
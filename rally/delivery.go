@@ -0,0 +1,264 @@
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rally
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDeliveryCacheSize = 10000
+	defaultDeliveryTTL       = 24 * time.Hour
+)
+
+// DeliveryCfg - struct
+type DeliveryCfg struct {
+	Size       int `json:"size"`
+	TTLMinutes int `json:"ttl_minutes"`
+}
+
+// DeliveryCache - the X-GitHub-Delivery ids seen recently, bounded in number and age.
+// When file is set each claim and release is appended to it so the ids survive a restart. The file is rewritten with the ids still
+// held once most of its lines are for ids that have been dropped, so saving a delivery costs the same however large the cache is.
+type DeliveryCache struct {
+	file  string
+	size  int
+	ttl   time.Duration
+	mut   sync.Mutex
+	seen  map[string]time.Time
+	order []string
+	log   *os.File
+	// logged is the number of lines in the file
+	logged int
+}
+
+type deliveryEntry struct {
+	ID       string    `json:"id"`
+	Seen     time.Time `json:"seen"`
+	Released bool      `json:"released,omitempty"`
+}
+
+// NewDeliveryCache - creates the cache, loading ids saved in file by a previous run
+func NewDeliveryCache(file string, cfg DeliveryCfg) (*DeliveryCache, error) {
+	d := &DeliveryCache{
+		file: file,
+		size: cfg.Size,
+		ttl:  time.Duration(cfg.TTLMinutes) * time.Minute,
+		seen: make(map[string]time.Time),
+	}
+
+	if d.size <= 0 {
+		d.size = defaultDeliveryCacheSize
+	}
+	if d.ttl <= 0 {
+		d.ttl = defaultDeliveryTTL
+	}
+
+	if file == "" {
+		return d, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return nil, err
+	}
+
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+	d.expire()
+
+	// Start from a file holding only the ids loaded
+	if err := d.compact(); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// load - replays the claims and releases in the file, one per line.
+func (d *DeliveryCache) load() error {
+	f, err := os.Open(d.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	for {
+		var e deliveryEntry
+		err := dec.Decode(&e)
+		// The last line is cut short when the process stopped part way through writing it
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if e.Released {
+			d.remove(e.ID)
+		} else {
+			d.add(e.ID, e.Seen)
+		}
+	}
+}
+
+// Claim - records the delivery id, returns false if it has already been seen
+func (d *DeliveryCache) Claim(id string) (bool, error) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	d.expire()
+
+	if _, ok := d.seen[id]; ok {
+		return false, nil
+	}
+
+	seen := time.Now().UTC()
+	d.add(id, seen)
+
+	return true, d.save(deliveryEntry{ID: id, Seen: seen})
+}
+
+// Release - forgets a claimed delivery id so a redelivery is processed, used when processing the delivery failed
+func (d *DeliveryCache) Release(id string) error {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	if _, ok := d.seen[id]; !ok {
+		return nil
+	}
+
+	d.remove(id)
+
+	return d.save(deliveryEntry{ID: id, Released: true})
+}
+
+// Close - closes the file the ids are saved in
+func (d *DeliveryCache) Close() error {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	if d.log == nil {
+		return nil
+	}
+
+	err := d.log.Close()
+	d.log = nil
+	return err
+}
+
+// add - holds the id, dropping the oldest ids when the cache is full
+func (d *DeliveryCache) add(id string, seen time.Time) {
+	if _, ok := d.seen[id]; ok {
+		return
+	}
+
+	d.seen[id] = seen
+	d.order = append(d.order, id)
+
+	for len(d.order) > d.size {
+		delete(d.seen, d.order[0])
+		d.order = d.order[1:]
+	}
+}
+
+func (d *DeliveryCache) remove(id string) {
+	if _, ok := d.seen[id]; !ok {
+		return
+	}
+
+	delete(d.seen, id)
+	for i, o := range d.order {
+		if o == id {
+			d.order = append(d.order[:i], d.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// expire - drops ids older than the ttl, ids are held in the order they were seen so only the front needs checking
+func (d *DeliveryCache) expire() {
+	cutoff := time.Now().Add(-d.ttl)
+
+	for len(d.order) > 0 && d.seen[d.order[0]].Before(cutoff) {
+		delete(d.seen, d.order[0])
+		d.order = d.order[1:]
+	}
+}
+
+// save - appends the entry to the file. The ids dropped for age or size are dropped again when the file is loaded,
+// so the file is only compacted once more than half of it is no longer needed.
+func (d *DeliveryCache) save(e deliveryEntry) error {
+	if d.log == nil {
+		return nil
+	}
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	if _, err = d.log.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	d.logged++
+
+	if d.logged >= d.size && d.logged > 2*len(d.order) {
+		return d.compact()
+	}
+	return nil
+}
+
+// compact - replaces the file with one line for each id held and reopens it for appending
+func (d *DeliveryCache) compact() error {
+	var buf bytes.Buffer
+	for _, id := range d.order {
+		b, err := json.Marshal(deliveryEntry{ID: id, Seen: d.seen[id]})
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+
+	if err := writeBytesAtomic(d.file, buf.Bytes()); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(d.file, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if d.log != nil {
+		d.log.Close()
+	}
+	d.log = f
+	d.logged = len(d.order)
+
+	return nil
+}
@@ -0,0 +1,160 @@
+// +build unit
+
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rally_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/comcast/github-rally-hook/rally"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var _ = Describe("Deduplicating webhook deliveries", func() {
+	var (
+		dir   string
+		file  string
+		cache *rally.DeliveryCache
+		err   error
+	)
+
+	BeforeEach(func() {
+		dir, err = ioutil.TempDir("", "deliveries")
+		Expect(err).ShouldNot(HaveOccurred())
+		file = filepath.Join(dir, "deliveries.json")
+
+		cache, err = rally.NewDeliveryCache(file, rally.DeliveryCfg{Size: 2})
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Describe("DeliveryCache", func() {
+		It("should only claim a delivery once", func() {
+			Expect(cache.Claim("72d3162e-cc78-11e3-81ab-4c9367dc0958")).Should(BeTrue())
+			Expect(cache.Claim("72d3162e-cc78-11e3-81ab-4c9367dc0958")).Should(BeFalse())
+		})
+
+		It("should remember deliveries across restarts", func() {
+			Expect(cache.Claim("72d3162e-cc78-11e3-81ab-4c9367dc0958")).Should(BeTrue())
+
+			reopened, err := rally.NewDeliveryCache(file, rally.DeliveryCfg{Size: 2})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(reopened.Claim("72d3162e-cc78-11e3-81ab-4c9367dc0958")).Should(BeFalse())
+		})
+
+		It("should forget the oldest deliveries when full", func() {
+			Expect(cache.Claim("first")).Should(BeTrue())
+			Expect(cache.Claim("second")).Should(BeTrue())
+			Expect(cache.Claim("third")).Should(BeTrue())
+
+			Expect(cache.Claim("first")).Should(BeTrue())
+			Expect(cache.Claim("third")).Should(BeFalse())
+		})
+
+		It("should claim a released delivery again", func() {
+			Expect(cache.Claim("first")).Should(BeTrue())
+			Expect(cache.Release("first")).Should(Succeed())
+			Expect(cache.Claim("first")).Should(BeTrue())
+		})
+		It("should remember releases across restarts", func() {
+			Expect(cache.Claim("first")).Should(BeTrue())
+			Expect(cache.Release("first")).Should(Succeed())
+
+			reopened, err := rally.NewDeliveryCache(file, rally.DeliveryCfg{Size: 2})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(reopened.Claim("first")).Should(BeTrue())
+		})
+
+		It("should keep the file to the deliveries it holds", func() {
+			for i := 0; i < 50; i++ {
+				Expect(cache.Claim(fmt.Sprintf("delivery-%d", i))).Should(BeTrue())
+			}
+
+			b, err := ioutil.ReadFile(file)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(strings.Count(string(b), "\n")).Should(BeNumerically("<=", 5))
+
+			reopened, err := rally.NewDeliveryCache(file, rally.DeliveryCfg{Size: 2})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(reopened.Claim("delivery-49")).Should(BeFalse())
+			Expect(reopened.Claim("delivery-47")).Should(BeTrue())
+		})
+	})
+
+	Describe("DeduplicateDeliveries", func() {
+		var (
+			calls    int
+			ctx      context.Context
+			response interface{}
+		)
+
+		BeforeEach(func() {
+			calls = 0
+			ctx = context.WithValue(context.Background(), rally.ContextKeyDelivery, "72d3162e-cc78-11e3-81ab-4c9367dc0958")
+		})
+
+		Context("when a delivery is received twice", func() {
+			It("should answer the second with a duplicate response", func() {
+				endpoint := rally.DeduplicateDeliveries(cache, log.NewNopLogger())(func(ctx context.Context, request interface{}) (interface{}, error) {
+					calls++
+					return rally.PushResponse{Result: "created"}, nil
+				})
+
+				response, err = endpoint(ctx, rally.PushEvent{})
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(response).Should(Equal(rally.PushResponse{Result: "created"}))
+
+				response, err = endpoint(ctx, rally.PushEvent{})
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(response).Should(Equal(rally.PushResponse{Result: "duplicate"}))
+				Expect(calls).Should(Equal(1))
+			})
+		})
+
+		Context("when the first delivery failed", func() {
+			It("should process the redelivery", func() {
+				endpoint := rally.DeduplicateDeliveries(cache, log.NewNopLogger())(func(ctx context.Context, request interface{}) (interface{}, error) {
+					calls++
+					if calls == 1 {
+						return nil, errors.New("workspace not found")
+					}
+					return rally.PushResponse{Result: "created"}, nil
+				})
+
+				_, err = endpoint(ctx, rally.PushEvent{})
+				Expect(err).Should(HaveOccurred())
+
+				response, err = endpoint(ctx, rally.PushEvent{})
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(response).Should(Equal(rally.PushResponse{Result: "created"}))
+				Expect(calls).Should(Equal(2))
+			})
+		})
+	})
+})
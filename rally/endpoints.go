@@ -35,3 +35,64 @@ func MakePushEventEndpoint(svc Service) endpoint.Endpoint {
 		return res, err
 	}
 }
+
+// MakePullRequestEventEndpoint - endpoint create
+func MakePullRequestEventEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(PullRequestEvent)
+
+		if !ok {
+			return nil, ErrInvalidArgument
+		}
+		res, err := svc.ReceivePullRequest(ctx, req)
+
+		return res, err
+	}
+}
+
+// MakeEventEndpoint - dispatches a decoded GitHub event to the endpoint for its type
+func MakeEventEndpoint(svc Service) endpoint.Endpoint {
+	push := MakePushEventEndpoint(svc)
+	pullRequest := MakePullRequestEventEndpoint(svc)
+
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		switch request.(type) {
+		case PushEvent:
+			return push(ctx, request)
+		case PullRequestEvent:
+			return pullRequest(ctx, request)
+		case UnsupportedEvent:
+			return PushResponse{Result: "ignored"}, nil
+		}
+		return nil, ErrInvalidArgument
+	}
+}
+
+// MakeDeadLettersEndpoint - endpoint to list dead letters
+func MakeDeadLettersEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		letters, err := svc.DeadLetters(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return DeadLettersResponse{DeadLetters: letters}, nil
+	}
+}
+
+// MakeRedriveEndpoint - endpoint to re-drive a dead letter
+func MakeRedriveEndpoint(svc Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req, ok := request.(RedriveRequest)
+
+		if !ok {
+			return nil, ErrInvalidArgument
+		}
+
+		if err := svc.Redrive(ctx, req.ID); err != nil {
+			return nil, err
+		}
+
+		return PushResponse{Result: "redriven"}, nil
+	}
+}
@@ -0,0 +1,246 @@
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rally
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LimitCfg - how fast and how many calls at once are sent to rally with one api key, zero leaves a setting unlimited
+type LimitCfg struct {
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	// Burst is how many calls may be sent at once after a quiet spell, it defaults to one second of requests
+	Burst       int `json:"burst"`
+	MaxInFlight int `json:"max_in_flight"`
+}
+
+func (c LimitCfg) empty() bool {
+	return c.RequestsPerSecond <= 0 && c.MaxInFlight <= 0
+}
+
+// LimiterStats - calls made through a named limiter and how long they queued for it
+type LimiterStats struct {
+	Name  string `json:"name"`
+	Calls uint64 `json:"calls"`
+	// Waits counts the calls that were held back, WaitSeconds is the total time they waited
+	Waits       uint64  `json:"waits"`
+	WaitSeconds float64 `json:"wait_seconds"`
+	InFlight    int64   `json:"in_flight"`
+}
+
+// limitTransport - holds requests back so each rally url and api key stays within its limits.
+// Every copy of the service shares the transport, so calls made for all routes with the same key are counted together.
+type limitTransport struct {
+	next     http.RoundTripper
+	mut      sync.RWMutex
+	limiters map[string]*limiter
+	names    []string
+}
+
+func newLimitTransport(next http.RoundTripper) *limitTransport {
+	return &limitTransport{
+		next:     next,
+		limiters: make(map[string]*limiter),
+	}
+}
+
+// add - limits the calls made to rallyURL with apiKey, the first configuration added for a key is kept
+func (t *limitTransport) add(name, rallyURL, apiKey string, cfg LimitCfg) {
+	key := limitKey(rallyURL, apiKey)
+
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	if _, ok := t.limiters[key]; ok {
+		return
+	}
+	t.limiters[key] = newLimiter(name, cfg)
+	t.names = append(t.names, key)
+}
+
+// Stats - the limiters in the order they were added
+func (t *limitTransport) Stats() []LimiterStats {
+	t.mut.RLock()
+	defer t.mut.RUnlock()
+
+	stats := make([]LimiterStats, 0, len(t.names))
+	for _, key := range t.names {
+		stats = append(stats, t.limiters[key].stats())
+	}
+	return stats
+}
+
+func (t *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mut.RLock()
+	l := t.limiters[req.URL.Host+" "+req.Header.Get("ZSESSIONID")]
+	t.mut.RUnlock()
+
+	if l == nil {
+		return t.next.RoundTrip(req)
+	}
+
+	release, err := l.acquire(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := t.next.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	// The call is in flight until its body has been read
+	response.Body = &releaseBody{ReadCloser: response.Body, release: release}
+	return response, nil
+}
+
+func limitKey(rallyURL, apiKey string) string {
+	var host string
+	if u, err := url.Parse(rallyURL); err == nil {
+		host = u.Host
+	}
+	return host + " " + apiKey
+}
+
+// limiter - a token bucket refilled at the configured rate and a semaphore capping the calls in flight
+type limiter struct {
+	// the counters are updated atomically and come first so they stay 64-bit aligned
+	calls     uint64
+	waits     uint64
+	waitNanos int64
+	inFlight  int64
+
+	name  string
+	rate  float64
+	burst float64
+	slots chan struct{}
+
+	mut    sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(name string, cfg LimitCfg) *limiter {
+	l := &limiter{
+		name:  name,
+		rate:  cfg.RequestsPerSecond,
+		burst: float64(cfg.Burst),
+		last:  time.Now(),
+	}
+
+	if l.burst <= 0 {
+		l.burst = math.Max(1, math.Ceil(l.rate))
+	}
+	l.tokens = l.burst
+
+	if cfg.MaxInFlight > 0 {
+		l.slots = make(chan struct{}, cfg.MaxInFlight)
+	}
+
+	return l
+}
+
+// acquire - waits for a token and a free slot, the returned func gives the slot back
+func (l *limiter) acquire(ctx context.Context) (func(), error) {
+	start := time.Now()
+
+	if delay := l.reserve(start); delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	atomic.AddUint64(&l.calls, 1)
+	atomic.AddInt64(&l.inFlight, 1)
+	if waited := time.Since(start); waited > time.Millisecond {
+		atomic.AddUint64(&l.waits, 1)
+		atomic.AddInt64(&l.waitNanos, int64(waited))
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			atomic.AddInt64(&l.inFlight, -1)
+			if l.slots != nil {
+				<-l.slots
+			}
+		})
+	}, nil
+}
+
+// reserve - takes a token and returns how long to wait before it is due.
+// Tokens are taken even when the bucket is empty so waiting calls are sent in the order they arrived.
+func (l *limiter) reserve(now time.Time) time.Duration {
+	if l.rate <= 0 {
+		return 0
+	}
+
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens--
+
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+func (l *limiter) stats() LimiterStats {
+	return LimiterStats{
+		Name:        l.name,
+		Calls:       atomic.LoadUint64(&l.calls),
+		Waits:       atomic.LoadUint64(&l.waits),
+		WaitSeconds: time.Duration(atomic.LoadInt64(&l.waitNanos)).Seconds(),
+		InFlight:    atomic.LoadInt64(&l.inFlight),
+	}
+}
+
+// releaseBody - frees the call's slot when the response body is closed
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
@@ -0,0 +1,208 @@
+// +build unit
+
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rally_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/comcast/github-rally-hook/rally"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var _ = Describe("Limiting rally calls", func() {
+	var (
+		server *ghttp.Server
+		svc    rally.Service
+		// noResults - a query finding nothing, so every lookup below is a single call
+		noResults = `{"QueryResult": {"Errors": [], "Warnings": [], "TotalResultCount": 0, "StartIndex": 1, "PageSize": 20, "Results": []}}`
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		server.AllowUnhandledRequests = false
+		svc = nil
+	})
+
+	AfterEach(func() {
+		if svc != nil {
+			svc.Close()
+		}
+		server.Close()
+	})
+
+	lookup := func(n int) {
+		svc.FindRallyArtifact(context.Background(), rally.Commit{Message: fmt.Sprintf("US%d - Fix the build", 10000+n)})
+	}
+
+	statsOf := func(name string) rally.LimiterStats {
+		for _, s := range svc.LimiterStats() {
+			if s.Name == name {
+				return s
+			}
+		}
+		return rally.LimiterStats{}
+	}
+
+	Context("with a rate limit", func() {
+		BeforeEach(func() {
+			server.RouteToHandler("GET", "/slm/webservice/v2.0/hierarchicalrequirement", ghttp.RespondWith(http.StatusOK, noResults))
+
+			var err error
+			svc, err = rally.NewPushReceiveService(log.NewNopLogger(), rally.Config{
+				RallyURL:  server.URL(),
+				APIToken:  "1234abcde",
+				Workspace: "Comcast",
+				RateLimit: rally.LimitCfg{RequestsPerSecond: 10, Burst: 1},
+			})
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		It("should space the calls out and report how long they waited", func() {
+			start := time.Now()
+			for n := 0; n < 4; n++ {
+				lookup(n)
+			}
+			Expect(time.Since(start)).Should(BeNumerically(">=", 250*time.Millisecond))
+			Expect(server.ReceivedRequests()).Should(HaveLen(4))
+
+			stats := statsOf("default")
+			Expect(stats.Calls).Should(BeNumerically("==", 4))
+			Expect(stats.Waits).Should(BeNumerically(">=", 2))
+			Expect(stats.WaitSeconds).Should(BeNumerically(">=", 0.2))
+			Expect(stats.InFlight).Should(BeZero())
+		})
+
+		It("should stop waiting once the caller's context is cancelled", func() {
+			lookup(0)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			svc.FindRallyArtifact(ctx, rally.Commit{Message: "US20000 - Fix the build"})
+
+			Expect(server.ReceivedRequests()).Should(HaveLen(1))
+		})
+	})
+
+	Context("with a cap on the calls in flight", func() {
+		var inFlight, most int32
+
+		BeforeEach(func() {
+			atomic.StoreInt32(&inFlight, 0)
+			atomic.StoreInt32(&most, 0)
+
+			server.RouteToHandler("GET", "/slm/webservice/v2.0/hierarchicalrequirement", func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&inFlight, 1)
+				for {
+					m := atomic.LoadInt32(&most)
+					if n <= m || atomic.CompareAndSwapInt32(&most, m, n) {
+						break
+					}
+				}
+				time.Sleep(50 * time.Millisecond)
+				atomic.AddInt32(&inFlight, -1)
+				w.Write([]byte(noResults))
+			})
+
+			var err error
+			svc, err = rally.NewPushReceiveService(log.NewNopLogger(), rally.Config{
+				RallyURL:  server.URL(),
+				APIToken:  "1234abcde",
+				Workspace: "Comcast",
+				RateLimit: rally.LimitCfg{MaxInFlight: 2},
+			})
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		It("should never send more calls at once than the cap", func() {
+			var wg sync.WaitGroup
+			for n := 0; n < 6; n++ {
+				wg.Add(1)
+				go func(n int) {
+					defer wg.Done()
+					lookup(n)
+				}(n)
+			}
+			wg.Wait()
+
+			Expect(server.ReceivedRequests()).Should(HaveLen(6))
+			Expect(atomic.LoadInt32(&most)).Should(BeNumerically("==", 2))
+
+			stats := statsOf("default")
+			Expect(stats.Calls).Should(BeNumerically("==", 6))
+			Expect(stats.Waits).Should(BeNumerically(">=", 1))
+			Expect(stats.InFlight).Should(BeZero())
+		})
+	})
+
+	Context("with routes", func() {
+		var w []byte
+
+		BeforeEach(func() {
+			var err error
+			w, err = ioutil.ReadFile("../fixtures/success_getWorkspace.json")
+			if err != nil {
+				Skip(err.Error())
+			}
+			server.RouteToHandler("GET", "/slm/webservice/v2.0/workspace", ghttp.RespondWith(http.StatusOK, string(w[:])))
+		})
+
+		It("should give a route with its own api key its own limit", func() {
+			var err error
+			svc, err = rally.NewPushReceiveService(log.NewNopLogger(), rally.Config{
+				RallyURL:  server.URL(),
+				APIToken:  "1234abcde",
+				Workspace: "Comcast",
+				RateLimit: rally.LimitCfg{RequestsPerSecond: 10},
+				Routes: []rally.RouteCfg{
+					{Repos: []string{"shared/*"}, Workspace: "Comcast"},
+					{Repos: []string{"team/*"}, Workspace: "Comcast", APIToken: "5678fghij", RateLimit: rally.LimitCfg{MaxInFlight: 1}},
+				},
+			})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			stats := svc.LimiterStats()
+			Expect(stats).Should(HaveLen(2))
+			Expect(stats[0].Name).Should(Equal("default"))
+			Expect(stats[1].Name).Should(Equal("route 1"))
+			Expect(stats[1].Calls).Should(BeNumerically("==", 1))
+		})
+
+		It("should refuse a route limit without an api key of its own", func() {
+			var err error
+			svc, err = rally.NewPushReceiveService(log.NewNopLogger(), rally.Config{
+				RallyURL:  server.URL(),
+				APIToken:  "1234abcde",
+				Workspace: "Comcast",
+				Routes: []rally.RouteCfg{
+					{Repos: []string{"team/*"}, Workspace: "Comcast", RateLimit: rally.LimitCfg{MaxInFlight: 1}},
+				},
+			})
+			Expect(err).Should(MatchError(ContainSubstring("route 0 has a rate_limit")))
+		})
+	})
+})
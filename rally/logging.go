@@ -44,9 +44,52 @@ func (l *loggingService) ReceivePush(ctx context.Context, request PushEvent) (re
 	return l.s.ReceivePush(ctx, request)
 }
 
-func (l *loggingService) FindRallyArtifact(commit Commit) (artifacts map[string]string) {
+func (l *loggingService) ReceivePullRequest(ctx context.Context, request PullRequestEvent) (response PushResponse, err error) {
+	defer func(start time.Time) {
+		l.logger.Log("event", "ReceivePullRequest", "err", err, "dur", time.Since(start))
+	}(time.Now())
+	return l.s.ReceivePullRequest(ctx, request)
+}
+
+func (l *loggingService) FindRallyArtifact(ctx context.Context, commit Commit) (artifacts map[string]string) {
 	defer func(start time.Time) {
 		l.logger.Log("event", "FindArtifacts", "dur", time.Since(start))
 	}(time.Now())
-	return l.s.FindRallyArtifact(commit)
+	return l.s.FindRallyArtifact(ctx, commit)
+}
+
+func (l *loggingService) DeadLetters(ctx context.Context) (letters []DeadLetter, err error) {
+	defer func(start time.Time) {
+		l.logger.Log("event", "DeadLetters", "count", len(letters), "err", err, "dur", time.Since(start))
+	}(time.Now())
+	return l.s.DeadLetters(ctx)
+}
+
+func (l *loggingService) Redrive(ctx context.Context, id string) (err error) {
+	defer func(start time.Time) {
+		l.logger.Log("event", "Redrive", "id", id, "err", err, "dur", time.Since(start))
+	}(time.Now())
+	return l.s.Redrive(ctx, id)
+}
+
+func (l *loggingService) CacheStats() []CacheStats {
+	return l.s.CacheStats()
+}
+
+func (l *loggingService) LimiterStats() []LimiterStats {
+	return l.s.LimiterStats()
+}
+
+func (l *loggingService) Shutdown(ctx context.Context) (err error) {
+	defer func(start time.Time) {
+		l.logger.Log("event", "Shutdown", "err", err, "dur", time.Since(start))
+	}(time.Now())
+	return l.s.Shutdown(ctx)
+}
+
+func (l *loggingService) Close() (err error) {
+	defer func(start time.Time) {
+		l.logger.Log("event", "Close", "err", err, "dur", time.Since(start))
+	}(time.Now())
+	return l.s.Close()
 }
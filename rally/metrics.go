@@ -25,11 +25,13 @@ import (
 )
 
 // NewInstrumentedService - contructor function to wrap Service for metrics
-func NewInstrumentedService(s Service, count metrics.Counter, callDur metrics.Histogram, c client.Client, in *kitinflux.Influx) Service {
+func NewInstrumentedService(s Service, count metrics.Counter, callDur metrics.Histogram, cache metrics.Gauge, limiter metrics.Gauge, c client.Client, in *kitinflux.Influx) Service {
 	return &instrumentedService{
 		s:       s,
 		count:   count,
 		callDur: callDur,
+		cache:   cache,
+		limiter: limiter,
 		c:       c,
 		in:      in,
 	}
@@ -39,6 +41,8 @@ type instrumentedService struct {
 	s       Service
 	count   metrics.Counter
 	callDur metrics.Histogram
+	cache   metrics.Gauge
+	limiter metrics.Gauge
 	c       client.Client
 	in      *kitinflux.Influx
 }
@@ -50,19 +54,114 @@ func (i *instrumentedService) ReceivePush(ctx context.Context, request PushEvent
 	defer func() {
 		counter.Add(1)
 		timer.ObserveDuration()
+		i.reportStats()
 	}()
 
 	return i.s.ReceivePush(ctx, request)
 }
 
-func (i *instrumentedService) FindRallyArtifact(commit Commit) (artifacts map[string]string) {
+func (i *instrumentedService) ReceivePullRequest(ctx context.Context, request PullRequestEvent) (PushResponse, error) {
+	counter := i.count.With("method", "ReceivePullRequest")
+	timer := metrics.NewTimer(i.callDur.With("method", "ReceivePullRequest"))
+
+	defer func() {
+		counter.Add(1)
+		timer.ObserveDuration()
+		i.reportStats()
+	}()
+
+	return i.s.ReceivePullRequest(ctx, request)
+}
+
+func (i *instrumentedService) FindRallyArtifact(ctx context.Context, commit Commit) (artifacts map[string]string) {
 	counter := i.count.With("method", "FindRallyArtifact")
 	timer := metrics.NewTimer(i.callDur.With("method", "FindRallyArtifact"))
 
 	defer func() {
 		counter.Add(1)
 		timer.ObserveDuration()
+		i.reportStats()
 	}()
 
-	return i.s.FindRallyArtifact(commit)
+	return i.s.FindRallyArtifact(ctx, commit)
+}
+
+func (i *instrumentedService) DeadLetters(ctx context.Context) ([]DeadLetter, error) {
+	counter := i.count.With("method", "DeadLetters")
+	timer := metrics.NewTimer(i.callDur.With("method", "DeadLetters"))
+
+	defer func() {
+		counter.Add(1)
+		timer.ObserveDuration()
+		i.reportStats()
+	}()
+
+	return i.s.DeadLetters(ctx)
+}
+
+func (i *instrumentedService) Redrive(ctx context.Context, id string) error {
+	counter := i.count.With("method", "Redrive")
+	timer := metrics.NewTimer(i.callDur.With("method", "Redrive"))
+
+	defer func() {
+		counter.Add(1)
+		timer.ObserveDuration()
+		i.reportStats()
+	}()
+
+	return i.s.Redrive(ctx, id)
+}
+
+func (i *instrumentedService) CacheStats() []CacheStats {
+	stats := i.s.CacheStats()
+	i.setCacheGauges(stats)
+
+	return stats
+}
+
+// Shutdown - shuts the service down then writes the metrics recorded since the last batch, they would be lost otherwise
+func (i *instrumentedService) Shutdown(ctx context.Context) error {
+	err := i.s.Shutdown(ctx)
+	i.reportStats()
+
+	if i.in != nil && i.c != nil {
+		if flushErr := i.in.WriteTo(i.c); flushErr != nil && err == nil {
+			err = flushErr
+		}
+	}
+
+	return err
+}
+
+func (i *instrumentedService) Close() error {
+	return i.s.Close()
+}
+
+func (i *instrumentedService) LimiterStats() []LimiterStats {
+	stats := i.s.LimiterStats()
+	i.setLimiterGauges(stats)
+
+	return stats
+}
+
+// reportStats - the caches and limiters are used by the workers, so their counts are refreshed on every call rather than when they change
+func (i *instrumentedService) reportStats() {
+	i.setCacheGauges(i.s.CacheStats())
+	i.setLimiterGauges(i.s.LimiterStats())
+}
+
+func (i *instrumentedService) setCacheGauges(stats []CacheStats) {
+	for _, c := range stats {
+		i.cache.With("cache", c.Name, "result", "hit").Set(float64(c.Hits))
+		i.cache.With("cache", c.Name, "result", "miss").Set(float64(c.Misses))
+	}
+}
+
+func (i *instrumentedService) setLimiterGauges(stats []LimiterStats) {
+	for _, l := range stats {
+		i.limiter.With("limiter", l.Name, "stat", "calls").Set(float64(l.Calls))
+		i.limiter.With("limiter", l.Name, "stat", "waits").Set(float64(l.Waits))
+		i.limiter.With("limiter", l.Name, "stat", "wait_seconds").Set(l.WaitSeconds)
+		i.limiter.With("limiter", l.Name, "stat", "in_flight").Set(float64(l.InFlight))
+	}
 }
@@ -19,9 +19,14 @@ package rally
 
 import (
 	"context"
-	"crypto"
-	"encoding/json"
-	"github.com/dgrijalva/jwt-go"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"hash"
+	"strings"
+
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
 )
@@ -45,30 +50,103 @@ func (a *Authorizor) ValidatePayload() endpoint.Middleware {
 	}
 }
 
+// CheckHMAC - verifies the GitHub signature headers against the raw request body captured by HTTPToContext.
+// X-Hub-Signature-256 is preferred, the legacy SHA1 X-Hub-Signature is accepted when it is the only one present.
 func (a *Authorizor) CheckHMAC(ctx context.Context, request interface{}) (err error) {
 	logger := log.With(a.Logger, "event", "CheckHMAC")
 
-	signature, ok := ctx.Value("X-Hub-Signature").(string)
+	signature256, has256 := ctx.Value(ContextKeySignature256).(string)
+	signature, has1 := ctx.Value(ContextKeySignature).(string)
 
 	// If the signature is not present and not required then bypass
-	if !ok && !a.SignatureRequired {
+	if !has256 && !has1 {
+		if a.SignatureRequired {
+			logger.Log("message", "signature required but not present")
+			return ErrUnauthorized
+		}
 		logger.Log("message", "signature not present")
 		return nil
 	}
 
-	signingMethod := jwt.SigningMethodHMAC{
-		"SHA1",
-		crypto.SHA1,
+	body, ok := ctx.Value(ContextKeyRawBody).([]byte)
+	if !ok {
+		logger.Log("message", "raw body not captured")
+		return ErrUnauthorized
 	}
 
-	requestBytes, err := json.Marshal(request.(PushEvent))
+	if has256 {
+		if !validSignature(sha256.New, "sha256=", signature256, body, a.SecretToken) {
+			return ErrUnauthorized
+		}
+		return nil
+	}
+
+	if !validSignature(sha1.New, "sha1=", signature, body, a.SecretToken) {
+		return ErrUnauthorized
+	}
+
+	return nil
+}
+
+// validSignature - compares a GitHub "<algorithm>=<hex>" signature with the HMAC of body in constant time
+func validSignature(h func() hash.Hash, prefix string, signature string, body []byte, secret string) bool {
+	if !strings.HasPrefix(signature, prefix) {
+		return false
+	}
 
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, prefix))
 	if err != nil {
-		return err
+		return false
 	}
-	if err = signingMethod.Verify(string(requestBytes[:]), signature, []byte(a.SecretToken)); err != nil {
-		return err
+
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(body)
+
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// RequireToken - rejects requests that do not carry "Authorization: Bearer <token>"
+func RequireToken(token string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			header, _ := ctx.Value(ContextKeyAuthorization).(string)
+
+			if token == "" || subtle.ConstantTimeCompare([]byte(header), []byte("Bearer "+token)) != 1 {
+				return nil, ErrUnauthorized
+			}
+
+			return next(ctx, request)
+		}
 	}
+}
 
-	return nil
+// DeduplicateDeliveries - answers deliveries whose X-GitHub-Delivery id has already been processed with a "duplicate" response,
+// so a redelivered webhook is never written to rally twice. A delivery that fails is forgotten so GitHub can redeliver it.
+func DeduplicateDeliveries(cache *DeliveryCache, logger log.Logger) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			id, ok := ctx.Value(ContextKeyDelivery).(string)
+			if !ok {
+				return next(ctx, request)
+			}
+
+			claimed, err := cache.Claim(id)
+			if err != nil {
+				logger.Log("event", "DeduplicateDeliveries", "delivery", id, "err", err.Error())
+			}
+			if !claimed {
+				logger.Log("event", "DeduplicateDeliveries", "delivery", id, "message", "duplicate delivery")
+				return PushResponse{Result: "duplicate"}, nil
+			}
+
+			response, err = next(ctx, request)
+			if err != nil {
+				if releaseErr := cache.Release(id); releaseErr != nil {
+					logger.Log("event", "DeduplicateDeliveries", "delivery", id, "err", releaseErr.Error())
+				}
+			}
+
+			return response, err
+		}
+	}
 }
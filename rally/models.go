@@ -17,15 +17,37 @@
 
 package rally
 
-import "time"
+import (
+	"github.com/comcast/github-rally-hook/github"
+	"time"
+)
 
 type Config struct {
-	RallyURL          string    `json:"rally-url"`
-	APIToken          string    `json:"api-key"`
-	Workspace         string    `json:"workspace"`
-	SecretToken       string    `json:"secret_token"`
-	SignatureRequired bool      `json:"signature_required"`
-	InfluxCfg         InfluxCfg `json:"influx_cfg"`
+	RallyURL          string            `json:"rally-url"`
+	APIToken          string            `json:"api-key"`
+	Workspace         string            `json:"workspace"`
+	Project           string            `json:"project"`
+	SecretToken       string            `json:"secret_token"`
+	SignatureRequired bool              `json:"signature_required"`
+	DataDir           string            `json:"data_dir"`
+	Workers           int               `json:"workers"`
+	AdminToken        string            `json:"admin_token"`
+	Retry             RetryCfg          `json:"retry"`
+	Timeouts          TimeoutCfg        `json:"timeouts"`
+	RateLimit         LimitCfg          `json:"rate_limit"`
+	Deliveries        DeliveryCfg       `json:"deliveries"`
+	Artifacts         ArtifactCfg       `json:"artifacts"`
+	States            StateCfg          `json:"states"`
+	Keywords          map[string]string `json:"keywords"`
+	Authors           AuthorCfg         `json:"authors"`
+	UserCache         CacheCfg          `json:"user_cache"`
+	ArtifactCache     CacheCfg          `json:"artifact_cache"`
+	Branches          BranchCfg         `json:"branches"`
+	Routes            []RouteCfg        `json:"routes"`
+	GitHub            github.Config     `json:"github"`
+	CommitStatus      bool              `json:"commit_status"`
+	PullRequestPolicy PolicyCfg         `json:"pull_request_policy"`
+	InfluxCfg         InfluxCfg         `json:"influx_cfg"`
 }
 
 // InfluxCfg - struct
@@ -42,6 +64,14 @@ type PushResponse struct {
 	Errors []error `json:"errors"`
 }
 
+type DeadLettersResponse struct {
+	DeadLetters []DeadLetter `json:"dead_letters"`
+}
+
+type RedriveRequest struct {
+	ID string `json:"id"`
+}
+
 type Reference struct {
 	Count         int    `json:",omitempty"`
 	Ref           string `json:"_ref,omitempty"`
@@ -70,28 +100,6 @@ type Changeset struct {
 	Uri             string      `json:",omitempty"`
 }
 
-type RallyQueryResults struct {
-	QueryResult struct {
-		RallyAPIMajor    string        `json:"_rallyAPIMajor"`
-		RallyAPIMinor    string        `json:"_rallyAPIMinor"`
-		Errors           []interface{} `json:"Errors"`
-		Warnings         []interface{} `json:"Warnings"`
-		TotalResultCount float64       `json:"TotalResultCount"`
-		StartIndex       int           `json:"StartIndex"`
-		PageSize         int           `json:"PageSize"`
-		Results          []RallyResult `json:"Results"`
-	} `json:"QueryResult"`
-}
-
-type RallyResult struct {
-	RallyAPIMajor string `json:"_rallyAPIMajor"`
-	RallyAPIMinor string `json:"_rallyAPIMinor"`
-	Ref           string `json:"_ref"`
-	RefObjectUUID string `json:"_refObjectUUID"`
-	RefObjectName string `json:"_refObjectName"`
-	Type          string `json:"_type"`
-}
-
 type Commit struct {
 	ID        string `json:"id"`
 	TreeID    string `json:"tree_id"`
@@ -100,12 +108,14 @@ type Commit struct {
 	Timestamp string `json:"timestamp"`
 	URL       string `json:"url"`
 	Author    struct {
-		Name  string `json:"name"`
-		Email string `json:"email"`
+		Name     string `json:"name"`
+		Email    string `json:"email"`
+		Username string `json:"username,omitempty"`
 	} `json:"author"`
 	Committer struct {
-		Name  string `json:"name"`
-		Email string `json:"email"`
+		Name     string `json:"name"`
+		Email    string `json:"email"`
+		Username string `json:"username,omitempty"`
 	} `json:"committer"`
 	Added    []string `json:"added"`
 	Removed  []string `json:"removed"`
@@ -244,261 +254,51 @@ type PushEvent struct {
 		Type              string `json:"type"`
 		SiteAdmin         bool   `json:"site_admin"`
 	} `json:"sender"`
+	Installation Installation `json:"installation"`
+}
+
+// Installation - the GitHub App installation a webhook was sent by, the ID is 0 for a webhook configured on the repository or org
+type Installation struct {
+	ID int64 `json:"id"`
 }
 
-type RallyCreateResult struct {
-	CreateResult struct {
-		RallyAPIMajor string        `json:"_rallyAPIMajor"`
-		RallyAPIMinor string        `json:"_rallyAPIMinor"`
-		Errors        []interface{} `json:"Errors"`
-		Warnings      []interface{} `json:"Warnings"`
-		Object        struct {
-			RallyAPIMajor string    `json:"_rallyAPIMajor"`
-			RallyAPIMinor string    `json:"_rallyAPIMinor"`
-			Ref           string    `json:"_ref"`
-			RefObjectUUID string    `json:"_refObjectUUID"`
-			ObjectVersion string    `json:"_objectVersion"`
-			RefObjectName string    `json:"_refObjectName"`
-			CreationDate  time.Time `json:"CreationDate"`
-			CreatedAt     string    `json:"_CreatedAt"`
-			ObjectID      int64     `json:"ObjectID"`
-			ObjectUUID    string    `json:"ObjectUUID"`
-			VersionID     string    `json:"VersionId"`
-			Subscription  struct {
-				RallyAPIMajor string `json:"_rallyAPIMajor"`
-				RallyAPIMinor string `json:"_rallyAPIMinor"`
-				Ref           string `json:"_ref"`
-				RefObjectUUID string `json:"_refObjectUUID"`
-				RefObjectName string `json:"_refObjectName"`
-				Type          string `json:"_type"`
-			} `json:"Subscription"`
-			Workspace struct {
-				RallyAPIMajor string `json:"_rallyAPIMajor"`
-				RallyAPIMinor string `json:"_rallyAPIMinor"`
-				Ref           string `json:"_ref"`
-				RefObjectUUID string `json:"_refObjectUUID"`
-				RefObjectName string `json:"_refObjectName"`
-				Type          string `json:"_type"`
-			} `json:"Workspace"`
-			Description string `json:"Description"`
-			Name        string `json:"Name"`
-			Projects    struct {
-				RallyAPIMajor string `json:"_rallyAPIMajor"`
-				RallyAPIMinor string `json:"_rallyAPIMinor"`
-				Ref           string `json:"_ref"`
-				Type          string `json:"_type"`
-				Count         int    `json:"Count"`
-			} `json:"Projects"`
-			SCMType string `json:"SCMType"`
-			URI     string `json:"Uri"`
-			Type    string `json:"_type"`
-		} `json:"Object"`
-	} `json:"CreateResult"`
+type PullRequestEvent struct {
+	Action      string `json:"action"`
+	Number      int    `json:"number"`
+	PullRequest struct {
+		ID      int    `json:"id"`
+		URL     string `json:"url"`
+		HTMLURL string `json:"html_url"`
+		State   string `json:"state"`
+		Title   string `json:"title"`
+		Body    string `json:"body"`
+		Merged  bool   `json:"merged"`
+		User    struct {
+			Login string `json:"login"`
+		} `json:"user"`
+		Head struct {
+			Ref string `json:"ref"`
+			SHA string `json:"sha"`
+		} `json:"head"`
+		Base struct {
+			Ref string `json:"ref"`
+			SHA string `json:"sha"`
+		} `json:"base"`
+	} `json:"pull_request"`
+	Repository struct {
+		ID       int    `json:"id"`
+		Name     string `json:"name"`
+		FullName string `json:"full_name"`
+		HTMLURL  string `json:"html_url"`
+		URL      string `json:"url"`
+	} `json:"repository"`
+	Sender struct {
+		Login string `json:"login"`
+	} `json:"sender"`
+	Installation Installation `json:"installation"`
 }
 
-type UpdateResult struct {
-	OperationResult struct {
-		RallyAPIMajor string        `json:"_rallyAPIMajor"`
-		RallyAPIMinor string        `json:"_rallyAPIMinor"`
-		Errors        []interface{} `json:"Errors"`
-		Warnings      []interface{} `json:"Warnings"`
-		Object        struct {
-			RallyAPIMajor string    `json:"_rallyAPIMajor"`
-			RallyAPIMinor string    `json:"_rallyAPIMinor"`
-			Ref           string    `json:"_ref"`
-			RefObjectUUID string    `json:"_refObjectUUID"`
-			ObjectVersion string    `json:"_objectVersion"`
-			RefObjectName string    `json:"_refObjectName"`
-			CreationDate  time.Time `json:"CreationDate"`
-			CreatedAt     string    `json:"_CreatedAt"`
-			ObjectID      int64     `json:"ObjectID"`
-			ObjectUUID    string    `json:"ObjectUUID"`
-			VersionID     string    `json:"VersionId"`
-			Subscription  struct {
-				RallyAPIMajor string `json:"_rallyAPIMajor"`
-				RallyAPIMinor string `json:"_rallyAPIMinor"`
-				Ref           string `json:"_ref"`
-				RefObjectUUID string `json:"_refObjectUUID"`
-				RefObjectName string `json:"_refObjectName"`
-				Type          string `json:"_type"`
-			} `json:"Subscription"`
-			Workspace struct {
-				RallyAPIMajor string `json:"_rallyAPIMajor"`
-				RallyAPIMinor string `json:"_rallyAPIMinor"`
-				Ref           string `json:"_ref"`
-				RefObjectUUID string `json:"_refObjectUUID"`
-				RefObjectName string `json:"_refObjectName"`
-				Type          string `json:"_type"`
-			} `json:"Workspace"`
-			Changesets struct {
-				RallyAPIMajor string `json:"_rallyAPIMajor"`
-				RallyAPIMinor string `json:"_rallyAPIMinor"`
-				Ref           string `json:"_ref"`
-				Type          string `json:"_type"`
-				Count         int    `json:"Count"`
-			} `json:"Changesets"`
-			Connections struct {
-				RallyAPIMajor string `json:"_rallyAPIMajor"`
-				RallyAPIMinor string `json:"_rallyAPIMinor"`
-				Ref           string `json:"_ref"`
-				Type          string `json:"_type"`
-				Count         int    `json:"Count"`
-			} `json:"Connections"`
-			CreatedBy struct {
-				RallyAPIMajor string `json:"_rallyAPIMajor"`
-				RallyAPIMinor string `json:"_rallyAPIMinor"`
-				Ref           string `json:"_ref"`
-				RefObjectUUID string `json:"_refObjectUUID"`
-				RefObjectName string `json:"_refObjectName"`
-				Type          string `json:"_type"`
-			} `json:"CreatedBy"`
-			Description string `json:"Description"`
-			Discussion  struct {
-				RallyAPIMajor string `json:"_rallyAPIMajor"`
-				RallyAPIMinor string `json:"_rallyAPIMinor"`
-				Ref           string `json:"_ref"`
-				Type          string `json:"_type"`
-				Count         int    `json:"Count"`
-			} `json:"Discussion"`
-			DisplayColor                 string      `json:"DisplayColor"`
-			Expedite                     bool        `json:"Expedite"`
-			FormattedID                  string      `json:"FormattedID"`
-			LastUpdateDate               time.Time   `json:"LastUpdateDate"`
-			LatestDiscussionAgeInMinutes interface{} `json:"LatestDiscussionAgeInMinutes"`
-			Milestones                   struct {
-				RallyAPIMajor string        `json:"_rallyAPIMajor"`
-				RallyAPIMinor string        `json:"_rallyAPIMinor"`
-				Ref           string        `json:"_ref"`
-				Type          string        `json:"_type"`
-				TagsNameArray []interface{} `json:"_tagsNameArray"`
-				Count         int           `json:"Count"`
-			} `json:"Milestones"`
-			Name  string `json:"Name"`
-			Notes string `json:"Notes"`
-			Owner struct {
-				RallyAPIMajor string `json:"_rallyAPIMajor"`
-				RallyAPIMinor string `json:"_rallyAPIMinor"`
-				Ref           string `json:"_ref"`
-				RefObjectUUID string `json:"_refObjectUUID"`
-				RefObjectName string `json:"_refObjectName"`
-				Type          string `json:"_type"`
-			} `json:"Owner"`
-			Project struct {
-				RallyAPIMajor string `json:"_rallyAPIMajor"`
-				RallyAPIMinor string `json:"_rallyAPIMinor"`
-				Ref           string `json:"_ref"`
-				RefObjectUUID string `json:"_refObjectUUID"`
-				RefObjectName string `json:"_refObjectName"`
-				Type          string `json:"_type"`
-			} `json:"Project"`
-			Ready           bool `json:"Ready"`
-			RevisionHistory struct {
-				RallyAPIMajor string `json:"_rallyAPIMajor"`
-				RallyAPIMinor string `json:"_rallyAPIMinor"`
-				Ref           string `json:"_ref"`
-				RefObjectUUID string `json:"_refObjectUUID"`
-				Type          string `json:"_type"`
-			} `json:"RevisionHistory"`
-			Tags struct {
-				RallyAPIMajor string        `json:"_rallyAPIMajor"`
-				RallyAPIMinor string        `json:"_rallyAPIMinor"`
-				Ref           string        `json:"_ref"`
-				Type          string        `json:"_type"`
-				TagsNameArray []interface{} `json:"_tagsNameArray"`
-				Count         int           `json:"Count"`
-			} `json:"Tags"`
-			FlowState struct {
-				RallyAPIMajor string `json:"_rallyAPIMajor"`
-				RallyAPIMinor string `json:"_rallyAPIMinor"`
-				Ref           string `json:"_ref"`
-				RefObjectUUID string `json:"_refObjectUUID"`
-				RefObjectName string `json:"_refObjectName"`
-				Type          string `json:"_type"`
-			} `json:"FlowState"`
-			FlowStateChangedDate time.Time   `json:"FlowStateChangedDate"`
-			LastBuild            interface{} `json:"LastBuild"`
-			LastRun              interface{} `json:"LastRun"`
-			PassingTestCaseCount int         `json:"PassingTestCaseCount"`
-			ScheduleState        string      `json:"ScheduleState"`
-			ScheduleStatePrefix  string      `json:"ScheduleStatePrefix"`
-			TestCaseCount        int         `json:"TestCaseCount"`
-			Attachments          struct {
-				RallyAPIMajor string `json:"_rallyAPIMajor"`
-				RallyAPIMinor string `json:"_rallyAPIMinor"`
-				Ref           string `json:"_ref"`
-				Type          string `json:"_type"`
-				Count         int    `json:"Count"`
-			} `json:"Attachments"`
-			Package       interface{} `json:"Package"`
-			AcceptedDate  interface{} `json:"AcceptedDate"`
-			Blocked       bool        `json:"Blocked"`
-			BlockedReason interface{} `json:"BlockedReason"`
-			Blocker       interface{} `json:"Blocker"`
-			Children      struct {
-				RallyAPIMajor string `json:"_rallyAPIMajor"`
-				RallyAPIMinor string `json:"_rallyAPIMinor"`
-				Ref           string `json:"_ref"`
-				Type          string `json:"_type"`
-				Count         int    `json:"Count"`
-			} `json:"Children"`
-			DefectStatus string `json:"DefectStatus"`
-			Defects      struct {
-				RallyAPIMajor string `json:"_rallyAPIMajor"`
-				RallyAPIMinor string `json:"_rallyAPIMinor"`
-				Ref           string `json:"_ref"`
-				Type          string `json:"_type"`
-				Count         int    `json:"Count"`
-			} `json:"Defects"`
-			DirectChildrenCount int         `json:"DirectChildrenCount"`
-			DragAndDropRank     string      `json:"DragAndDropRank"`
-			HasParent           bool        `json:"HasParent"`
-			InProgressDate      time.Time   `json:"InProgressDate"`
-			Iteration           interface{} `json:"Iteration"`
-			Parent              interface{} `json:"Parent"`
-			PlanEstimate        interface{} `json:"PlanEstimate"`
-			Predecessors        struct {
-				RallyAPIMajor string `json:"_rallyAPIMajor"`
-				RallyAPIMinor string `json:"_rallyAPIMinor"`
-				Ref           string `json:"_ref"`
-				Type          string `json:"_type"`
-				Count         int    `json:"Count"`
-			} `json:"Predecessors"`
-			Recycled bool        `json:"Recycled"`
-			Release  interface{} `json:"Release"`
-			Risks    struct {
-				RallyAPIMajor string `json:"_rallyAPIMajor"`
-				RallyAPIMinor string `json:"_rallyAPIMinor"`
-				Ref           string `json:"_ref"`
-				Type          string `json:"_type"`
-				Count         int    `json:"Count"`
-			} `json:"Risks"`
-			Successors struct {
-				RallyAPIMajor string `json:"_rallyAPIMajor"`
-				RallyAPIMinor string `json:"_rallyAPIMinor"`
-				Ref           string `json:"_ref"`
-				Type          string `json:"_type"`
-				Count         int    `json:"Count"`
-			} `json:"Successors"`
-			TaskActualTotal    int    `json:"TaskActualTotal"`
-			TaskEstimateTotal  int    `json:"TaskEstimateTotal"`
-			TaskRemainingTotal int    `json:"TaskRemainingTotal"`
-			TaskStatus         string `json:"TaskStatus"`
-			Tasks              struct {
-				RallyAPIMajor string `json:"_rallyAPIMajor"`
-				RallyAPIMinor string `json:"_rallyAPIMinor"`
-				Ref           string `json:"_ref"`
-				Type          string `json:"_type"`
-				Count         int    `json:"Count"`
-			} `json:"Tasks"`
-			TestCaseStatus string `json:"TestCaseStatus"`
-			TestCases      struct {
-				RallyAPIMajor string `json:"_rallyAPIMajor"`
-				RallyAPIMinor string `json:"_rallyAPIMinor"`
-				Ref           string `json:"_ref"`
-				Type          string `json:"_type"`
-				Count         int    `json:"Count"`
-			} `json:"TestCases"`
-			Type string `json:"_type"`
-		} `json:"Object"`
-	} `json:"OperationResult"`
+// UnsupportedEvent - a GitHub event type the service does not act on
+type UnsupportedEvent struct {
+	Name string
 }
@@ -0,0 +1,150 @@
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rally
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	"sort"
+	"strings"
+)
+
+// policyContext - names the check run reporting the pull request policy
+const policyContext = "rally/policy"
+
+// PolicyCfg - struct
+type PolicyCfg struct {
+	// RequireArtifact fails pull requests whose title, branch and commits do not reference a rally artifact
+	RequireArtifact bool `json:"require_artifact"`
+	// States the referenced artifact must be in, as its ScheduleState or State. Any state is allowed when empty.
+	States []string `json:"states"`
+}
+
+// policyAction - whether the pull request policy is checked for a pull request action
+func policyAction(action string) bool {
+	switch action {
+	case "opened", "reopened", "synchronize", "edited":
+		return true
+	}
+	return false
+}
+
+// checkPolicy - checks the pull request references an artifact in an allowed state and reports the result on its head commit
+func (s *service) checkPolicy(ctx context.Context, event PullRequestEvent, r *route, workspaceRef string, logger log.Logger) {
+	pr := event.PullRequest
+	repo := event.Repository.FullName
+
+	texts := []string{pr.Title, pr.Head.Ref}
+
+	commits, err := s.github.PullRequestCommits(ctx, repo, event.Number, event.Installation.ID)
+	if err != nil {
+		// Without the commits the title and branch are still checked, the check can only fail wrongly
+		logger.Log("PullRequestCommits", event.Number, "err", err.Error())
+	}
+	for _, c := range commits {
+		texts = append(texts, c.Commit.Message)
+	}
+
+	refs := r.svc.findArtifacts(ctx, strings.Join(texts, "\n"), workspaceRef)
+
+	ids := make([]string, 0, len(refs))
+	for id := range refs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var (
+		summary strings.Builder
+		passed  []string
+	)
+
+	for _, id := range ids {
+		link := rallyLink(s.cfg.RallyURL, refs[id])
+
+		if len(s.cfg.PullRequestPolicy.States) == 0 {
+			fmt.Fprintf(&summary, "- [%s](%s)\n", id, link)
+			passed = append(passed, id)
+			continue
+		}
+
+		state, err := r.svc.artifactState(ctx, refs[id])
+		if err != nil {
+			logger.Log("ArtifactState", id, "err", err.Error())
+			fmt.Fprintf(&summary, "- [%s](%s) state could not be read\n", id, link)
+			continue
+		}
+
+		if containsFold(s.cfg.PullRequestPolicy.States, state) {
+			fmt.Fprintf(&summary, "- [%s](%s) is %s\n", id, link, state)
+			passed = append(passed, id)
+		} else {
+			fmt.Fprintf(&summary, "- [%s](%s) is %s, it must be one of %s\n", id, link, state, strings.Join(s.cfg.PullRequestPolicy.States, ", "))
+		}
+	}
+
+	var title string
+	switch {
+	case len(passed) > 0:
+		title = "References " + strings.Join(passed, ", ")
+	case len(ids) > 0:
+		title = "No referenced Rally artifact is in an allowed state"
+	default:
+		title = "No Rally artifact is referenced"
+		summary.WriteString("Reference a Rally artifact, e.g. US1234, in the pull request title, branch name or a commit message.\n")
+	}
+
+	var targetURL string
+	if len(passed) > 0 {
+		targetURL = rallyLink(s.cfg.RallyURL, refs[passed[0]])
+	}
+
+	err = s.report(ctx, repo, pr.Head.SHA, event.Installation.ID, policyContext, len(passed) > 0, title, summary.String(), targetURL)
+	if err != nil {
+		logger.Log("ReportPolicy", event.Number, "err", err.Error())
+	}
+}
+
+// artifactState - the ScheduleState of an artifact, or its State when it has no schedule state
+func (s *service) artifactState(ctx context.Context, ref string) (string, error) {
+	artifact, err := s.rally.Get(ctx, ref, "ScheduleState", "State")
+	if err != nil {
+		return "", err
+	}
+
+	if state := artifact.String("ScheduleState"); state != "" {
+		return state, nil
+	}
+
+	// Portfolio item states are objects of their own, String gives their name
+	if state := artifact.String("State"); state != "" {
+		return state, nil
+	}
+
+	return "", errors.New("artifact has no state")
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
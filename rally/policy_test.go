@@ -0,0 +1,196 @@
+// +build unit
+
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rally_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/comcast/github-rally-hook/github"
+	"github.com/comcast/github-rally-hook/rally"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"io/ioutil"
+	"net/http"
+)
+
+var _ = Describe("Requiring rally references on pull requests", func() {
+	var (
+		server  *ghttp.Server
+		gh      *ghttp.Server
+		svc     rally.Service
+		prEvent rally.PullRequestEvent
+		cfg     rally.Config
+		w       []byte
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		server.AllowUnhandledRequests = false
+		gh = ghttp.NewServer()
+		gh.AllowUnhandledRequests = false
+
+		prReq, err := ioutil.ReadFile("../fixtures/sample_pullrequest_event.json")
+		if err != nil {
+			Skip(err.Error())
+		}
+
+		err = json.NewDecoder(bytes.NewReader(prReq)).Decode(&prEvent)
+		if err != nil {
+			Skip(err.Error())
+		}
+		prEvent.Action = "opened"
+
+		w, err = ioutil.ReadFile("../fixtures/success_getWorkspace.json")
+		if err != nil {
+			Skip(err.Error())
+		}
+
+		cfg = rally.Config{
+			RallyURL:  server.URL(),
+			APIToken:  "1234abcde",
+			Workspace: "Comcast",
+			GitHub: github.Config{
+				URL:   gh.URL(),
+				Token: "gh-token",
+			},
+			PullRequestPolicy: rally.PolicyCfg{
+				RequireArtifact: true,
+				States:          []string{"Defined", "In-Progress"},
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		svc, err = rally.NewPushReceiveService(log.NewNopLogger(), cfg)
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+		gh.Close()
+	})
+
+	Context("when the branch references an artifact in an allowed state", func() {
+		BeforeEach(func() {
+			us, err := ioutil.ReadFile("../fixtures/success_getUserStory.json")
+			if err != nil {
+				Skip(err.Error())
+			}
+
+			st, err := ioutil.ReadFile("../fixtures/success_getUserStoryState.json")
+			if err != nil {
+				Skip(err.Error())
+			}
+
+			cp, err := ioutil.ReadFile("../fixtures/success_createConversationPost.json")
+			if err != nil {
+				Skip(err.Error())
+			}
+
+			server.AppendHandlers(
+				ghttp.RespondWith(http.StatusOK, string(w[:])),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/hierarchicalrequirement"),
+					ghttp.RespondWith(http.StatusOK, string(us[:])),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/hierarchicalrequirement/271167421104", "fetch=ScheduleState%2CState"),
+					ghttp.RespondWith(http.StatusOK, string(st[:])),
+				),
+				// Opening the pull request is posted on the story too, the story is cached by then
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/conversationpost/create"),
+					ghttp.RespondWith(http.StatusOK, string(cp[:])),
+				),
+			)
+
+			gh.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/repos/ABC/data-service/pulls/42/commits"),
+					ghttp.RespondWith(http.StatusOK, `[]`),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/repos/ABC/data-service/statuses/"+prEvent.PullRequest.Head.SHA),
+					ghttp.VerifyJSONRepresenting(github.Status{
+						State:       github.StateSuccess,
+						TargetURL:   server.URL() + "/#/detail/userstory/271167421104",
+						Description: "References US12345",
+						Context:     "rally/policy",
+					}),
+					ghttp.RespondWith(http.StatusCreated, `{}`),
+				),
+			)
+		})
+
+		It("should pass the check", func() {
+			response, err := svc.ReceivePullRequest(context.Background(), prEvent)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(response.Result).Should(Equal("created"))
+			Eventually(gh.ReceivedRequests).Should(HaveLen(2))
+			Eventually(server.ReceivedRequests).Should(HaveLen(4))
+		})
+	})
+
+	Context("when nothing references an artifact", func() {
+		BeforeEach(func() {
+			prEvent.Action = "synchronize"
+			prEvent.PullRequest.Title = "Fix a typo"
+			prEvent.PullRequest.Head.Ref = "fix-typo"
+
+			server.AppendHandlers(
+				ghttp.RespondWith(http.StatusOK, string(w[:])),
+			)
+
+			gh.AppendHandlers(
+				ghttp.RespondWith(http.StatusOK, `[{"sha": "39820cb", "commit": {"message": "Fix a typo"}}]`),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/repos/ABC/data-service/statuses/"+prEvent.PullRequest.Head.SHA),
+					ghttp.VerifyJSONRepresenting(github.Status{
+						State:       github.StateFailure,
+						Description: "No Rally artifact is referenced",
+						Context:     "rally/policy",
+					}),
+					ghttp.RespondWith(http.StatusCreated, `{}`),
+				),
+			)
+		})
+
+		It("should fail the check", func() {
+			response, err := svc.ReceivePullRequest(context.Background(), prEvent)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(response.Result).Should(Equal("created"))
+			Eventually(gh.ReceivedRequests).Should(HaveLen(2))
+			Expect(server.ReceivedRequests()).Should(HaveLen(1))
+		})
+	})
+
+	Context("when there are no github credentials", func() {
+		It("should fail to create the service", func() {
+			cfg.GitHub = github.Config{}
+
+			_, err := rally.NewPushReceiveService(log.NewNopLogger(), cfg)
+			Expect(err).Should(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,197 @@
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rally
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PushJob - a webhook event waiting to be written to rally, either a push or a pull request
+type PushJob struct {
+	ID          string            `json:"id"`
+	Received    time.Time         `json:"received"`
+	Event       PushEvent         `json:"event"`
+	PullRequest *PullRequestEvent `json:"pull_request,omitempty"`
+}
+
+// PushQueue - ordered queue of push jobs, each job is written to its own file so it survives a restart until acknowledged.
+// When dir is empty the queue is held in memory only.
+type PushQueue struct {
+	dir     string
+	mut     sync.Mutex
+	seq     int
+	pending []PushJob
+	ready   chan struct{}
+}
+
+// NewPushQueue - opens the queue in dir, jobs left over from a previous run are loaded in the order they were received
+func NewPushQueue(dir string) (*PushQueue, error) {
+	q := &PushQueue{
+		dir:   dir,
+		ready: make(chan struct{}, 1),
+	}
+
+	if dir == "" {
+		return q, nil
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	for _, f := range files {
+		b, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		var job PushJob
+		if err = json.Unmarshal(b, &job); err != nil {
+			return nil, fmt.Errorf("corrupt queue entry %s: %s", f, err.Error())
+		}
+		q.pending = append(q.pending, job)
+	}
+
+	if len(q.pending) > 0 {
+		q.signal()
+	}
+
+	return q, nil
+}
+
+// Enqueue - assigns the job an id, persists it and makes it available to the workers
+func (q *PushQueue) Enqueue(job PushJob) (PushJob, error) {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	q.seq++
+	job.ID = fmt.Sprintf("%020d-%06d", time.Now().UnixNano(), q.seq)
+	job.Received = time.Now().UTC()
+
+	if q.dir != "" {
+		if err := writeFileAtomic(q.path(job.ID), job); err != nil {
+			return PushJob{}, err
+		}
+	}
+
+	q.pending = append(q.pending, job)
+	q.signal()
+
+	return job, nil
+}
+
+// Next - takes the oldest job off the queue, the job stays on disk until Ack is called
+func (q *PushQueue) Next() (PushJob, bool) {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	if len(q.pending) == 0 {
+		return PushJob{}, false
+	}
+
+	job := q.pending[0]
+	q.pending = q.pending[1:]
+
+	// Wake another worker if there is more to do
+	if len(q.pending) > 0 {
+		q.signal()
+	}
+
+	return job, true
+}
+
+// Ack - removes a processed job from disk
+func (q *PushQueue) Ack(id string) error {
+	if q.dir == "" {
+		return nil
+	}
+
+	if err := os.Remove(q.path(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Len - number of jobs waiting for a worker
+func (q *PushQueue) Len() int {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+
+	return len(q.pending)
+}
+
+// Ready - receives when jobs are waiting
+func (q *PushQueue) Ready() <-chan struct{} {
+	return q.ready
+}
+
+func (q *PushQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (q *PushQueue) path(id string) string {
+	return filepath.Join(q.dir, id+".json")
+}
+
+// writeFileAtomic - writes v as json to a temporary file and renames it into place so a crash never leaves a partial file
+func writeFileAtomic(path string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return writeBytesAtomic(path, b)
+}
+
+// writeBytesAtomic - writes b to a temporary file and renames it into place
+func writeBytesAtomic(path string, b []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+strings.TrimSuffix(filepath.Base(path), ".json")+"-*")
+	if err != nil {
+		return err
+	}
+
+	if _, err = tmp.Write(b); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,164 @@
+// +build unit
+
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rally_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/comcast/github-rally-hook/rally"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+var _ = Describe("A durable queue of push events", func() {
+	var (
+		dir   string
+		queue *rally.PushQueue
+		err   error
+	)
+
+	BeforeEach(func() {
+		dir, err = ioutil.TempDir("", "push-queue")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		queue, err = rally.NewPushQueue(dir)
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	Context("when pushes are queued and the service restarts before they are processed", func() {
+		var first, second rally.PushJob
+
+		BeforeEach(func() {
+			first, err = queue.Enqueue(rally.PushJob{Event: rally.PushEvent{Ref: "refs/heads/first"}})
+			Expect(err).ShouldNot(HaveOccurred())
+			second, err = queue.Enqueue(rally.PushJob{Event: rally.PushEvent{Ref: "refs/heads/second"}})
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		It("should resume the pushes in the order they were received", func() {
+			reopened, err := rally.NewPushQueue(dir)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(reopened.Len()).Should(Equal(2))
+
+			job, ok := reopened.Next()
+			Expect(ok).Should(BeTrue())
+			Expect(job.ID).Should(Equal(first.ID))
+			Expect(job.Event.Ref).Should(Equal("refs/heads/first"))
+
+			job, ok = reopened.Next()
+			Expect(ok).Should(BeTrue())
+			Expect(job.ID).Should(Equal(second.ID))
+		})
+
+		It("should not resume pushes that have been acknowledged", func() {
+			job, _ := queue.Next()
+			Expect(queue.Ack(job.ID)).Should(Succeed())
+
+			files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+			Expect(files).Should(HaveLen(1))
+
+			reopened, err := rally.NewPushQueue(dir)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(reopened.Len()).Should(Equal(1))
+
+			job, _ = reopened.Next()
+			Expect(job.ID).Should(Equal(second.ID))
+		})
+	})
+
+	Context("when the service has no data_dir", func() {
+		It("should warn that queued pushes are lost on restart", func() {
+			var buf bytes.Buffer
+			svc, err := rally.NewPushReceiveService(log.NewLogfmtLogger(&buf), rally.Config{RallyURL: "http://localhost", Workspace: "Comcast"})
+			Expect(err).ShouldNot(HaveOccurred())
+			defer svc.Close()
+
+			Expect(buf.String()).Should(ContainSubstring("data_dir is not set"))
+		})
+
+		It("should not warn when pushes are persisted", func() {
+			var buf bytes.Buffer
+			svc, err := rally.NewPushReceiveService(log.NewLogfmtLogger(&buf), rally.Config{RallyURL: "http://localhost", Workspace: "Comcast", DataDir: dir})
+			Expect(err).ShouldNot(HaveOccurred())
+			defer svc.Close()
+
+			Expect(buf.String()).ShouldNot(ContainSubstring("data_dir is not set"))
+		})
+	})
+
+	Context("when rally is down as a push arrives", func() {
+		var (
+			server    *ghttp.Server
+			svc       rally.Service
+			pushEvent rally.PushEvent
+		)
+
+		BeforeEach(func() {
+			pushReq, err := ioutil.ReadFile("../fixtures/sample_pushevent.json")
+			if err != nil {
+				Skip(err.Error())
+			}
+			Expect(json.Unmarshal(pushReq, &pushEvent)).Should(Succeed())
+
+			server = ghttp.NewServer()
+			server.AllowUnhandledRequests = false
+			server.RouteToHandler("GET", "/slm/webservice/v2.0/workspace", ghttp.RespondWith(http.StatusServiceUnavailable, ""))
+
+			svc, err = rally.NewPushReceiveService(log.NewNopLogger(), rally.Config{
+				RallyURL:  server.URL(),
+				APIToken:  "1234abcde",
+				Workspace: "Comcast",
+				DataDir:   dir,
+				Retry:     rally.RetryCfg{Attempts: 1},
+			})
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			svc.Close()
+			server.Close()
+		})
+
+		It("should accept the push and keep its commits to be re-driven", func() {
+			response, err := svc.ReceivePush(context.Background(), pushEvent)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(response.Result).Should(Equal("created"))
+
+			letters := func() []rally.DeadLetter {
+				l, _ := svc.DeadLetters(context.Background())
+				return l
+			}
+			Eventually(letters).Should(HaveLen(len(pushEvent.Commits)))
+			Expect(letters()[0].Operation).Should(Equal(rally.OpGetOrCreateSCMRepository))
+			Expect(letters()[0].WorkspaceRef).Should(BeEmpty())
+		})
+	})
+})
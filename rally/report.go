@@ -0,0 +1,211 @@
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rally
+
+import (
+	"context"
+	"fmt"
+	"github.com/comcast/github-rally-hook/github"
+	"github.com/comcast/github-rally-hook/rally/wsapi"
+	"github.com/go-kit/kit/log"
+	"sort"
+	"strings"
+)
+
+// statusContext - names the commit status and check run the hook reports on each commit
+const statusContext = "rally"
+
+// rallyUITypes - the type used in rally's detail page url where it differs from the WSAPI type
+var rallyUITypes = map[string]string{
+	"hierarchicalrequirement": "userstory",
+}
+
+// stateUpdate - the outcome of moving an artifact for a commit
+type stateUpdate struct {
+	FormattedID string
+	Ref         string
+	Transition  string
+	Err         error
+}
+
+// commitReport - what happened to the rally ids in a commit message
+type commitReport struct {
+	linked     []string
+	unresolved []string
+	updates    []stateUpdate
+	refs       map[string]string
+	// changeSetErr is why the commit's changeset was not written, the ids found are then not linked to it
+	changeSetErr error
+}
+
+func newCommitReport(ids []artifactID, refs map[string]string, updates []stateUpdate, changeSetErr error) commitReport {
+	r := commitReport{refs: refs, updates: updates, changeSetErr: changeSetErr}
+
+	for _, id := range ids {
+		if _, ok := refs[id.FormattedID]; ok {
+			r.linked = append(r.linked, id.FormattedID)
+		} else {
+			r.unresolved = append(r.unresolved, id.FormattedID)
+		}
+	}
+
+	sort.Slice(r.updates, func(i, j int) bool { return r.updates[i].FormattedID < r.updates[j].FormattedID })
+
+	return r
+}
+
+// ok - the changeset was written, every id resolved and every state change succeeded
+func (r commitReport) ok() bool {
+	if r.changeSetErr != nil || len(r.unresolved) > 0 {
+		return false
+	}
+	for _, u := range r.updates {
+		if u.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// description - a single line summary for a commit status
+func (r commitReport) description() string {
+	var parts []string
+
+	switch {
+	case r.changeSetErr != nil && len(r.linked) > 0:
+		parts = append(parts, "changeset not recorded for "+strings.Join(r.linked, ", "))
+	case r.changeSetErr != nil:
+		parts = append(parts, "changeset not recorded")
+	case len(r.linked) > 0:
+		parts = append(parts, "Linked "+strings.Join(r.linked, ", "))
+	}
+	if len(r.unresolved) > 0 {
+		parts = append(parts, "not found "+strings.Join(r.unresolved, ", "))
+	}
+
+	var failed int
+	for _, u := range r.updates {
+		if u.Err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		parts = append(parts, fmt.Sprintf("%d state change(s) failed", failed))
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+// summary - a markdown list of each id with a link to it in rally, for a check run
+func (r commitReport) summary(rallyURL string) string {
+	var b strings.Builder
+
+	updates := make(map[string]stateUpdate, len(r.updates))
+	for _, u := range r.updates {
+		updates[u.FormattedID] = u
+	}
+
+	linked := "linked"
+	if r.changeSetErr != nil {
+		fmt.Fprintf(&b, "The changeset was not recorded in Rally: %s\n\n", r.changeSetErr.Error())
+		linked = "found"
+	}
+
+	for _, id := range r.linked {
+		fmt.Fprintf(&b, "- [%s](%s) %s", id, rallyLink(rallyURL, r.refs[id]), linked)
+		if u, ok := updates[id]; ok {
+			if u.Err != nil {
+				fmt.Fprintf(&b, ", %s failed: %s", u.Transition, u.Err.Error())
+			} else {
+				fmt.Fprintf(&b, ", marked %s", u.Transition)
+			}
+		}
+		b.WriteString("\n")
+	}
+	for _, id := range r.unresolved {
+		fmt.Fprintf(&b, "- %s was not found in Rally\n", id)
+	}
+
+	return b.String()
+}
+
+// rallyLink - the page of an artifact in rally's web app
+func rallyLink(rallyURL string, ref string) string {
+	artifactType, objectID := wsapi.ParseRef(ref)
+	if t, ok := rallyUITypes[artifactType]; ok {
+		artifactType = t
+	}
+
+	return fmt.Sprintf("%s/#/detail/%s/%s", strings.TrimSuffix(rallyURL, "/"), artifactType, objectID)
+}
+
+// reportCommit - reports the rally ids in a commit back to GitHub, changeSetErr is why the changeset could not be written when it wasn't.
+// Commits without rally ids are not reported. Failures to report are only logged.
+func (s *service) reportCommit(ctx context.Context, c Commit, repo string, installationID int64, refs map[string]string, updates []stateUpdate, changeSetErr error, logger log.Logger) {
+	ids := s.artifacts.find(c.Message)
+	if s.github == nil || len(ids) == 0 {
+		return
+	}
+
+	report := newCommitReport(ids, refs, updates, changeSetErr)
+
+	var targetURL string
+	if len(report.linked) > 0 {
+		targetURL = rallyLink(s.cfg.RallyURL, refs[report.linked[0]])
+	}
+
+	err := s.report(ctx, repo, c.ID, installationID, statusContext, report.ok(), report.description(), report.summary(s.cfg.RallyURL), targetURL)
+	if err != nil {
+		logger.Log("ReportCommit", c.ID, "err", err.Error())
+	}
+}
+
+// report - sets the outcome of a check on sha, as a check run with the markdown summary when calling GitHub as an App,
+// otherwise as a commit status with the title as its description
+func (s *service) report(ctx context.Context, repo string, sha string, installationID int64, name string, ok bool, title string, summary string, targetURL string) error {
+	if s.github.IsApp() {
+		conclusion := github.ConclusionSuccess
+		if !ok {
+			conclusion = github.ConclusionFailure
+		}
+
+		_, err := s.github.CreateCheckRun(ctx, repo, github.CheckRun{
+			Name:       name,
+			HeadSHA:    sha,
+			Conclusion: conclusion,
+			DetailsURL: targetURL,
+			Output: &github.CheckRunOutput{
+				Title:   title,
+				Summary: summary,
+			},
+		}, installationID)
+		return err
+	}
+
+	status := github.Status{
+		State:       github.StateSuccess,
+		TargetURL:   targetURL,
+		Description: title,
+		Context:     name,
+	}
+	if !ok {
+		status.State = github.StateFailure
+	}
+
+	return s.github.CreateStatus(ctx, repo, sha, status, installationID)
+}
@@ -0,0 +1,186 @@
+// +build unit
+
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rally_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/comcast/github-rally-hook/github"
+	"github.com/comcast/github-rally-hook/rally"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"io/ioutil"
+	"net/http"
+)
+
+var _ = Describe("Reporting commits back to GitHub", func() {
+	var (
+		server    *ghttp.Server
+		gh        *ghttp.Server
+		svc       rally.Service
+		pushEvent rally.PushEvent
+		cfg       rally.Config
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		server.AllowUnhandledRequests = false
+		gh = ghttp.NewServer()
+		gh.AllowUnhandledRequests = false
+
+		fixtures := map[string][]byte{}
+		for _, f := range []string{"success_getWorkspace", "success_getSCMRepo", "success_getUserStories", "success_getUser", "success_updateStateStarts",
+			"success_getChangeSet_none", "success_createChangeSet", "success_createChange", "sample_pushevent"} {
+			b, err := ioutil.ReadFile("../fixtures/" + f + ".json")
+			if err != nil {
+				Skip(err.Error())
+			}
+			fixtures[f] = b
+		}
+
+		err := json.NewDecoder(bytes.NewReader(fixtures["sample_pushevent"])).Decode(&pushEvent)
+		if err != nil {
+			Skip(err.Error())
+		}
+		pushEvent.Commits[0].Message = "STARTS US12345 with US12346, follows US99999"
+
+		server.RouteToHandler("GET", "/slm/webservice/v2.0/workspace", ghttp.RespondWith(http.StatusOK, string(fixtures["success_getWorkspace"])))
+		server.RouteToHandler("GET", "/slm/webservice/v2.0/scmrepository", ghttp.RespondWith(http.StatusOK, string(fixtures["success_getSCMRepo"])))
+		server.RouteToHandler("GET", "/slm/webservice/v2.0/hierarchicalrequirement", ghttp.RespondWith(http.StatusOK, string(fixtures["success_getUserStories"])))
+		server.RouteToHandler("GET", "/slm/webservice/v2.0/user", ghttp.RespondWith(http.StatusOK, string(fixtures["success_getUser"])))
+		server.RouteToHandler("POST", "/slm/webservice/v2.0/hierarchicalrequirement/271167421104", ghttp.RespondWith(http.StatusOK, string(fixtures["success_updateStateStarts"])))
+		server.RouteToHandler("GET", "/slm/webservice/v2.0/changeset", ghttp.RespondWith(http.StatusOK, string(fixtures["success_getChangeSet_none"])))
+		server.RouteToHandler("POST", "/slm/webservice/v2.0/changeset/create", ghttp.RespondWith(http.StatusOK, string(fixtures["success_createChangeSet"])))
+		server.RouteToHandler("POST", "/slm/webservice/v2.0/change/create", ghttp.RespondWith(http.StatusOK, string(fixtures["success_createChange"])))
+
+		cfg = rally.Config{
+			RallyURL:     server.URL(),
+			APIToken:     "1234abcde",
+			Workspace:    "Comcast",
+			CommitStatus: true,
+			GitHub: github.Config{
+				URL:   gh.URL(),
+				Token: "gh-token",
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		svc, err = rally.NewPushReceiveService(log.NewNopLogger(), cfg)
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+		gh.Close()
+	})
+
+	Context("when a commit references an artifact that does not exist", func() {
+		BeforeEach(func() {
+			gh.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/repos/ABC/data-service/statuses/"+pushEvent.Commits[0].ID),
+					ghttp.VerifyJSONRepresenting(github.Status{
+						State:       github.StateFailure,
+						TargetURL:   server.URL() + "/#/detail/userstory/271167421104",
+						Description: "Linked US12345, US12346; not found US99999",
+						Context:     "rally",
+					}),
+					ghttp.RespondWith(http.StatusCreated, `{}`),
+				),
+			)
+		})
+
+		It("should set a failed status listing what was linked", func() {
+			_, err := svc.ReceivePush(context.Background(), pushEvent)
+			Expect(err).ShouldNot(HaveOccurred())
+			Eventually(gh.ReceivedRequests).Should(HaveLen(1))
+		})
+	})
+
+	Context("when the changeset cannot be created", func() {
+		BeforeEach(func() {
+			server.RouteToHandler("POST", "/slm/webservice/v2.0/changeset/create",
+				ghttp.RespondWith(http.StatusOK, `{"CreateResult": {"Errors": ["Concurrency conflict: [Object has been modified since being read for update in this context]"], "Warnings": []}}`))
+
+			gh.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/repos/ABC/data-service/statuses/"+pushEvent.Commits[0].ID),
+					ghttp.VerifyJSONRepresenting(github.Status{
+						State:       github.StateFailure,
+						TargetURL:   server.URL() + "/#/detail/userstory/271167421104",
+						Description: "changeset not recorded for US12345, US12346; not found US99999",
+						Context:     "rally",
+					}),
+					ghttp.RespondWith(http.StatusCreated, `{}`),
+				),
+			)
+		})
+
+		It("should set a failed status rather than say the artifacts were linked", func() {
+			_, err := svc.ReceivePush(context.Background(), pushEvent)
+			Expect(err).ShouldNot(HaveOccurred())
+			Eventually(gh.ReceivedRequests).Should(HaveLen(1))
+		})
+	})
+
+	Context("when the SCM repository cannot be found or created", func() {
+		BeforeEach(func() {
+			server.RouteToHandler("GET", "/slm/webservice/v2.0/scmrepository",
+				ghttp.RespondWith(http.StatusOK, `{"QueryResult": {"Errors": ["Not authorized to perform action: Invalid key"], "Warnings": []}}`))
+
+			gh.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/repos/ABC/data-service/statuses/"+pushEvent.Commits[0].ID),
+					ghttp.VerifyJSONRepresenting(github.Status{
+						State:       github.StateFailure,
+						TargetURL:   server.URL() + "/#/detail/userstory/271167421104",
+						Description: "changeset not recorded for US12345, US12346; not found US99999",
+						Context:     "rally",
+					}),
+					ghttp.RespondWith(http.StatusCreated, `{}`),
+				),
+			)
+		})
+
+		It("should still report the commit as failed", func() {
+			_, err := svc.ReceivePush(context.Background(), pushEvent)
+			Expect(err).ShouldNot(HaveOccurred())
+			Eventually(gh.ReceivedRequests).Should(HaveLen(1))
+		})
+	})
+
+	Context("when commit statuses are not enabled", func() {
+		BeforeEach(func() {
+			cfg.CommitStatus = false
+		})
+
+		It("should not call GitHub", func() {
+			_, err := svc.ReceivePush(context.Background(), pushEvent)
+			Expect(err).ShouldNot(HaveOccurred())
+			Eventually(func() int { return len(server.ReceivedRequests()) }).Should(Equal(8))
+			Consistently(gh.ReceivedRequests).Should(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,212 @@
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rally
+
+import (
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultRetryAttempts       = 3
+	defaultRetryInitialBackoff = 500 * time.Millisecond
+	defaultRetryMaxBackoff     = 30 * time.Second
+	defaultCallTimeout         = 60 * time.Second
+)
+
+// RetryCfg - struct
+type RetryCfg struct {
+	Attempts         int `json:"attempts"`
+	InitialBackoffMs int `json:"initial_backoff_ms"`
+	MaxBackoffMs     int `json:"max_backoff_ms"`
+}
+
+// TimeoutCfg - how long a single call may take, including its retries, and how long shutting down may take
+type TimeoutCfg struct {
+	RallyMs  int `json:"rally_ms"`
+	GitHubMs int `json:"github_ms"`
+	// ShutdownMs is how long shutting down waits for webhooks and pushes in flight
+	ShutdownMs int `json:"shutdown_ms"`
+}
+
+// newHTTPClient - a client sending through next, retrying with cfg, that gives up on a call after timeoutMs
+func newHTTPClient(next http.RoundTripper, cfg RetryCfg, timeoutMs int) *http.Client {
+	timeout := time.Duration(timeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultCallTimeout
+	}
+
+	return &http.Client{
+		Transport: NewRetryTransport(next, cfg),
+		Timeout:   timeout,
+	}
+}
+
+// NewRetryTransport - wraps next so failed requests, 429 and 5xx gateway responses are retried with exponential backoff and jitter.
+// A Retry-After header on the response takes precedence over the computed backoff.
+func NewRetryTransport(next http.RoundTripper, cfg RetryCfg) http.RoundTripper {
+	t := &retryTransport{
+		next:           next,
+		attempts:       cfg.Attempts,
+		initialBackoff: time.Duration(cfg.InitialBackoffMs) * time.Millisecond,
+		maxBackoff:     time.Duration(cfg.MaxBackoffMs) * time.Millisecond,
+	}
+
+	if t.attempts <= 0 {
+		t.attempts = defaultRetryAttempts
+	}
+	if t.initialBackoff <= 0 {
+		t.initialBackoff = defaultRetryInitialBackoff
+	}
+	if t.maxBackoff <= 0 {
+		t.maxBackoff = defaultRetryMaxBackoff
+	}
+
+	return t
+}
+
+type retryTransport struct {
+	next           http.RoundTripper
+	attempts       int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var (
+		response *http.Response
+		err      error
+	)
+
+	for attempt := 1; ; attempt++ {
+		// The caller's request is never changed, each attempt after the first is sent with a copy and a fresh body
+		attemptReq := req
+		if attempt > 1 && req.GetBody != nil {
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return nil, bodyErr
+			}
+			attemptReq = new(http.Request)
+			*attemptReq = *req
+			attemptReq.Body = body
+		}
+
+		response, err = t.next.RoundTrip(attemptReq)
+
+		if !t.shouldRetry(req, response, err) {
+			return response, err
+		}
+
+		// A body that has been consumed can't be sent again
+		if attempt >= t.attempts || (req.Body != nil && req.GetBody == nil) {
+			return response, err
+		}
+
+		wait := t.backoff(attempt)
+		if response != nil {
+			if after, ok := retryAfter(response.Header.Get("Retry-After")); ok {
+				wait = after
+			}
+			io.Copy(ioutil.Discard, response.Body)
+			response.Body.Close()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// shouldRetry - whether the attempt failed in a way that is safe to try again. A POST such as a create may already have been
+// applied when the connection drops or the gateway times out, so it is only sent again when rally turned it away or it was never sent.
+func (t *retryTransport) shouldRetry(req *http.Request, response *http.Response, err error) bool {
+	if idempotent(req.Method) {
+		return err != nil || retryable(response.StatusCode)
+	}
+
+	if err != nil {
+		return notSent(err)
+	}
+
+	switch response.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	}
+	return false
+}
+
+// backoff - full jitter exponential backoff for the given attempt
+func (t *retryTransport) backoff(attempt int) time.Duration {
+	ceiling := t.initialBackoff << uint(attempt-1)
+	if ceiling <= 0 || ceiling > t.maxBackoff {
+		ceiling = t.maxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(ceiling)) + 1)
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// notSent - the connection could not be made, so the request never reached rally
+func notSent(err error) bool {
+	op, ok := err.(*net.OpError)
+	return ok && op.Op == "dial"
+}
+
+func retryable(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter - parses a Retry-After header given either in seconds or as an HTTP date
+func retryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		wait := time.Until(date)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+
+	return 0, false
+}
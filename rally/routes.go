@@ -0,0 +1,185 @@
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rally
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/comcast/github-rally-hook/rally/wsapi"
+	"path"
+	"sync"
+)
+
+// RouteCfg - sends the events of matching repositories to their own workspace
+type RouteCfg struct {
+	// Repos are globs matched against the repository full name, e.g. "my-org/*". The first matching route is used.
+	Repos     []string `json:"repos"`
+	Workspace string   `json:"workspace"`
+	// Project is optional, it must be in the workspace
+	Project string `json:"project"`
+	// APIToken replaces the default api-key for the route when set
+	APIToken string `json:"api-key"`
+	// Keywords are merged over the default keywords, in the same form
+	Keywords map[string]string `json:"keywords"`
+	// Branches replaces the default branches when set
+	Branches BranchCfg `json:"branches"`
+	// RateLimit replaces the default rate limit for the route's own api key, routes sharing a key share its limit
+	RateLimit LimitCfg `json:"rate_limit"`
+}
+
+// route - a routing rule and the rally references it resolved to
+type route struct {
+	cfg RouteCfg
+	// svc is a copy of the service using the route's api key and keywords
+	svc *service
+
+	mut          sync.Mutex
+	workspaceRef string
+	projectRef   string
+}
+
+// newRoutes - validates the configured routes and resolves their workspaces and projects, so a typo fails at startup rather than on a push
+func (s *service) newRoutes(routes []RouteCfg) ([]*route, error) {
+	resolved := make([]*route, 0, len(routes))
+
+	for i, rc := range routes {
+		if rc.Workspace == "" {
+			return nil, fmt.Errorf("route %d has no workspace", i)
+		}
+		if len(rc.Repos) == 0 {
+			return nil, fmt.Errorf("route %d has no repos", i)
+		}
+		for _, p := range rc.Repos {
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("route %d repo %q: %s", i, p, err.Error())
+			}
+		}
+		if err := rc.Branches.validate(); err != nil {
+			return nil, fmt.Errorf("route %d %s", i, err.Error())
+		}
+		if rc.Branches.empty() {
+			rc.Branches = s.cfg.Branches
+		}
+		if !rc.RateLimit.empty() && rc.APIToken == "" {
+			return nil, fmt.Errorf("route %d has a rate_limit but no api-key of its own", i)
+		}
+		if rc.RateLimit.empty() {
+			rc.RateLimit = s.cfg.RateLimit
+		}
+		if rc.APIToken != "" {
+			s.limits.add(fmt.Sprintf("route %d", i), s.cfg.RallyURL, rc.APIToken, rc.RateLimit)
+		}
+
+		svc, err := s.withRoute(rc)
+		if err != nil {
+			return nil, fmt.Errorf("route %d: %s", i, err.Error())
+		}
+
+		r := &route{cfg: rc, svc: svc}
+		if _, _, err = r.resolve(s.ctx); err != nil {
+			return nil, fmt.Errorf("route %d: %s", i, err.Error())
+		}
+
+		resolved = append(resolved, r)
+	}
+
+	return resolved, nil
+}
+
+// withRoute - copies the service with the route's api key and keywords, everything else is shared
+func (s *service) withRoute(rc RouteCfg) (*service, error) {
+	rs := *s
+	rs.cfg.Workspace = rc.Workspace
+	if rc.APIToken != "" {
+		rs.cfg.APIToken = rc.APIToken
+		rs.rally = s.rally.WithAPIKey(rc.APIToken)
+	}
+
+	keywords := make(map[string]string, len(s.cfg.Keywords)+len(rc.Keywords))
+	for k, t := range s.cfg.Keywords {
+		keywords[k] = t
+	}
+	for k, t := range rc.Keywords {
+		keywords[k] = t
+	}
+	rs.keywords = newKeywordMatcher(keywords)
+
+	var err error
+	if rs.authors, err = NewAuthorResolver(s.cfg.Authors, rs.lookupUser); err != nil {
+		return nil, err
+	}
+
+	return &rs, nil
+}
+
+// routeFor - the route of a repository, the default route when none of the configured routes match
+func (s *service) routeFor(fullName string) *route {
+	for _, r := range s.routes {
+		if matchAny(r.cfg.Repos, fullName) {
+			return r
+		}
+	}
+
+	return s.defaultRoute
+}
+
+// resolve - looks up the route's workspace and project, they are cached once found
+func (r *route) resolve(ctx context.Context) (workspaceRef string, projectRef string, err error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	if r.workspaceRef != "" {
+		return r.workspaceRef, r.projectRef, nil
+	}
+
+	workspaceRef, ok := r.svc.ValidateOrg(ctx, r.cfg.Workspace)
+	if !ok {
+		return "", "", errors.New("workspace not found")
+	}
+
+	if r.cfg.Project != "" {
+		if projectRef, ok = r.svc.findProject(ctx, r.cfg.Project, workspaceRef); !ok {
+			return "", "", fmt.Errorf("project %s not found", r.cfg.Project)
+		}
+	}
+
+	r.workspaceRef = workspaceRef
+	r.projectRef = projectRef
+
+	return workspaceRef, projectRef, nil
+}
+
+// findProject - looks up a project by name within a workspace
+func (s *service) findProject(ctx context.Context, name string, workspaceRef string) (string, bool) {
+	results, err := s.rally.Query(ctx, wsapi.Query{
+		Type:      "project",
+		Where:     wsapi.Equal("Name", wsapi.Quote(name)),
+		Workspace: workspaceRef,
+		Limit:     2,
+	})
+	if err != nil {
+		return "", false
+	}
+
+	if len(results) == 1 {
+		return results[0].Ref(), true
+	}
+
+	return "", false
+}
@@ -0,0 +1,240 @@
+// +build unit
+
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rally_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/comcast/github-rally-hook/rally"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"io/ioutil"
+	"net/http"
+)
+
+var _ = Describe("Routing repositories to workspaces", func() {
+	var (
+		server *ghttp.Server
+		cfg    rally.Config
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		server.AllowUnhandledRequests = false
+
+		cfg = rally.Config{
+			RallyURL:  server.URL(),
+			APIToken:  "1234abcde",
+			Workspace: "Comcast",
+			Routes: []rally.RouteCfg{
+				{
+					Repos:     []string{"XYZ/*", "ABC/data-*"},
+					Workspace: "Data",
+					Project:   "Data Team",
+					APIToken:  "route-key",
+				},
+			},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Context("when a push comes from a routed repository", func() {
+		var (
+			svc       rally.Service
+			pushEvent rally.PushEvent
+		)
+
+		BeforeEach(func() {
+			w, err := ioutil.ReadFile("../fixtures/success_getWorkspace.json")
+			if err != nil {
+				Skip(err.Error())
+			}
+
+			p, err := ioutil.ReadFile("../fixtures/success_getProject.json")
+			if err != nil {
+				Skip(err.Error())
+			}
+
+			gs, err := ioutil.ReadFile("../fixtures/success_getSCMRepo_none.json")
+			if err != nil {
+				Skip(err.Error())
+			}
+
+			cs, err := ioutil.ReadFile("../fixtures/success_create_scmrepo.json")
+			if err != nil {
+				Skip(err.Error())
+			}
+
+			u, err := ioutil.ReadFile("../fixtures/success_getUser.json")
+			if err != nil {
+				Skip(err.Error())
+			}
+
+			us, err := ioutil.ReadFile("../fixtures/success_getUserStory.json")
+			if err != nil {
+				Skip(err.Error())
+			}
+
+			gcs, err := ioutil.ReadFile("../fixtures/success_getChangeSet_none.json")
+			if err != nil {
+				Skip(err.Error())
+			}
+
+			chset, err := ioutil.ReadFile("../fixtures/success_createChangeSet.json")
+			if err != nil {
+				Skip(err.Error())
+			}
+
+			ch, err := ioutil.ReadFile("../fixtures/success_createChange.json")
+			if err != nil {
+				Skip(err.Error())
+			}
+
+			pushReq, err := ioutil.ReadFile("../fixtures/sample_pushevent.json")
+			if err != nil {
+				Skip(err.Error())
+			}
+
+			err = json.NewDecoder(bytes.NewReader(pushReq)).Decode(&pushEvent)
+			if err != nil {
+				Skip(err.Error())
+			}
+
+			routeKey := http.Header{"Zsessionid": []string{"route-key"}}
+			inWorkspace := ghttp.VerifyFormKV("workspace", "https://rally1.rallydev.com/slm/webservice/v2.0/workspace/12345678")
+
+			server.AppendHandlers(
+				// Resolved at startup
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/workspace", "query=%28name+%3D+%22Data%22%29"),
+					ghttp.VerifyHeader(routeKey),
+					ghttp.RespondWith(http.StatusOK, string(w[:])),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/project",
+						"query=%28Name+%3D+%22Data+Team%22%29&workspace=https%3A%2F%2Frally1.rallydev.com%2Fslm%2Fwebservice%2Fv2.0%2Fworkspace%2F12345678"),
+					ghttp.VerifyHeader(routeKey),
+					ghttp.RespondWith(http.StatusOK, string(p[:])),
+				),
+				// The push
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/scmrepository",
+						"query=%28Uri+%3D+%22https%3A%2F%2Fsomegithub.com%2FABC%2Fdata-service%22%29&workspace=https%3A%2F%2Frally1.rallydev.com%2Fslm%2Fwebservice%2Fv2.0%2Fworkspace%2F12345678"),
+					ghttp.VerifyHeader(routeKey),
+					ghttp.RespondWith(http.StatusOK, string(gs[:])),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/scmrepository/create"),
+					ghttp.VerifyHeader(routeKey),
+					inWorkspace,
+					func(w http.ResponseWriter, r *http.Request) {
+						var body map[string]map[string]interface{}
+						Expect(json.NewDecoder(r.Body).Decode(&body)).Should(Succeed())
+						Expect(body["SCMRepository"]["Uri"]).Should(Equal("https://somegithub.com/ABC/data-service"))
+						Expect(body["SCMRepository"]["Workspace"]).Should(Equal("https://rally1.rallydev.com/slm/webservice/v2.0/workspace/12345678"))
+						Expect(body["SCMRepository"]["Projects"]).Should(Equal([]interface{}{
+							map[string]interface{}{"_ref": "https://rally1.rallydev.com/slm/webservice/v2.0/project/87654321"},
+						}))
+					},
+					ghttp.RespondWith(http.StatusOK, string(cs[:])),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/hierarchicalrequirement",
+						"fetch=FormattedID&query=%28FormattedID+%3D+US12345%29&workspace=https%3A%2F%2Frally1.rallydev.com%2Fslm%2Fwebservice%2Fv2.0%2Fworkspace%2F12345678"),
+					ghttp.VerifyHeader(routeKey),
+					ghttp.RespondWith(http.StatusOK, string(us[:])),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/user"),
+					ghttp.VerifyHeader(routeKey),
+					ghttp.RespondWith(http.StatusOK, string(u[:])),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/changeset"),
+					ghttp.VerifyHeader(routeKey),
+					inWorkspace,
+					ghttp.RespondWith(http.StatusOK, string(gcs[:])),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/changeset/create"),
+					ghttp.VerifyHeader(routeKey),
+					inWorkspace,
+					ghttp.RespondWith(http.StatusOK, string(chset[:])),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/change/create"),
+					ghttp.VerifyHeader(routeKey),
+					inWorkspace,
+					ghttp.RespondWith(http.StatusOK, string(ch[:])),
+				),
+			)
+
+			svc, err = rally.NewPushReceiveService(log.NewNopLogger(), cfg)
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		It("should create the repository in the route's project and write the changeset with the route's api key", func() {
+			Expect(server.ReceivedRequests()).Should(HaveLen(2))
+
+			response, err := svc.ReceivePush(context.Background(), pushEvent)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(response.Result).Should(Equal("created"))
+
+			Eventually(server.ReceivedRequests).Should(HaveLen(9))
+		})
+	})
+
+	Context("when a route's workspace does not exist", func() {
+		BeforeEach(func() {
+			w, err := ioutil.ReadFile("../fixtures/success_getWorkspace_none.json")
+			if err != nil {
+				Skip(err.Error())
+			}
+
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/workspace"),
+					ghttp.RespondWith(http.StatusOK, string(w[:])),
+				),
+			)
+		})
+
+		It("should fail to create the service", func() {
+			_, err := rally.NewPushReceiveService(log.NewNopLogger(), cfg)
+			Expect(err).Should(MatchError("route 0: workspace not found"))
+		})
+	})
+
+	Context("when a route has an invalid repo pattern", func() {
+		It("should fail to create the service", func() {
+			cfg.Routes[0].Repos = []string{"ABC/["}
+
+			_, err := rally.NewPushReceiveService(log.NewNopLogger(), cfg)
+			Expect(err).Should(HaveOccurred())
+			Expect(server.ReceivedRequests()).Should(BeEmpty())
+		})
+	})
+})
@@ -18,452 +18,883 @@
 package rally
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/comcast/github-rally-hook/github"
+	"github.com/comcast/github-rally-hook/rally/wsapi"
 	"github.com/go-kit/kit/log"
+	"html"
 	"net/http"
-	"net/url"
-	"regexp"
+	"path/filepath"
+	"sort"
 	"strings"
-	"sync"
 )
 
 type Service interface {
 	ReceivePush(ctx context.Context, event PushEvent) (PushResponse, error)
-	FindRallyArtifact(commit Commit) (artifacts map[string]string)
+	ReceivePullRequest(ctx context.Context, event PullRequestEvent) (PushResponse, error)
+	FindRallyArtifact(ctx context.Context, commit Commit) (artifacts map[string]string)
+	DeadLetters(ctx context.Context) ([]DeadLetter, error)
+	Redrive(ctx context.Context, id string) error
+	CacheStats() []CacheStats
+	LimiterStats() []LimiterStats
+	Shutdown(ctx context.Context) error
+	Close() error
 }
 
 type service struct {
-	logger log.Logger
-	mut    sync.Mutex
-	cfg    Config
-	client *http.Client
+	// ctx is the parent of every call the workers make, it is cancelled when the service is closed
+	ctx          context.Context
+	cancel       context.CancelFunc
+	workers      *workerState
+	logger       log.Logger
+	cfg          Config
+	client       *http.Client
+	limits       *limitTransport
+	queue        *PushQueue
+	deadLetters  *DeadLetterStore
+	artifacts    *artifactMatcher
+	states       StateCfg
+	keywords     *keywordMatcher
+	authors      AuthorResolver
+	users        *ttlCache
+	artifactRefs *ttlCache
+	scmRepos     *ttlCache
+	rally        *wsapi.Client
+	github       *github.Client
+	routes       []*route
+	defaultRoute *route
 }
 
-var userCache map[string]string
+// pushTarget - where the commits of a push are written in rally
+type pushTarget struct {
+	Repo         string
+	FullName     string
+	RepoURL      string
+	Branch       string
+	WorkspaceRef string
+	ProjectRef   string
+	SCMRepo      string
+}
+
+// defaultWorkers - number of pushes processed concurrently when the config does not set workers
+const defaultWorkers = 4
 
-func NewPushReceiveService(l log.Logger, cfg Config) Service {
-	return &service{
-		logger: l,
-		cfg:    cfg,
-		client: &http.Client{},
+func NewPushReceiveService(l log.Logger, cfg Config) (Service, error) {
+	artifacts, err := newArtifactMatcher(cfg.Artifacts)
+	if err != nil {
+		return nil, err
 	}
-}
 
-func (s *service) ReceivePush(ctx context.Context, event PushEvent) (response PushResponse, err error) {
+	var queueDir, deadLetterDir string
+	if cfg.DataDir != "" {
+		queueDir = filepath.Join(cfg.DataDir, "queue")
+		deadLetterDir = filepath.Join(cfg.DataDir, "deadletter")
+	} else {
+		l.Log("event", "NewPushReceiveService", "warning", "data_dir is not set, queued pushes and dead letters are held in memory and lost on restart")
+	}
 
-	var (
-		branch  string
-		repo    string
-		repoURL string
-	)
+	queue, err := NewPushQueue(queueDir)
+	if err != nil {
+		return nil, err
+	}
 
-	logger := log.With(s.logger, "event", "ReceivePush")
-	splitRef := strings.Split(event.Ref, "/")
+	deadLetters, err := NewDeadLetterStore(deadLetterDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Retries are limited too, each attempt is a call rally counts
+	limits := newLimitTransport(http.DefaultTransport)
+	limits.add("default", cfg.RallyURL, cfg.APIToken, cfg.RateLimit)
+
+	s := &service{
+		logger:       l,
+		cfg:          cfg,
+		client:       newHTTPClient(limits, cfg.Retry, cfg.Timeouts.RallyMs),
+		limits:       limits,
+		queue:        queue,
+		deadLetters:  deadLetters,
+		artifacts:    artifacts,
+		states:       mergeStates(cfg.States),
+		keywords:     newKeywordMatcher(cfg.Keywords),
+		users:        newTTLCache("user", cfg.UserCache, defaultCacheTTL, defaultCacheNegativeTTL),
+		artifactRefs: newTTLCache("artifact", cfg.ArtifactCache, defaultArtifactCacheTTL, defaultArtifactCacheNegativeTTL),
+		scmRepos:     newTTLCache("scmrepository", CacheCfg{}, defaultCacheTTL, 0),
+	}
+
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.workers = newWorkerState()
+	s.rally = wsapi.NewClient(cfg.RallyURL, cfg.APIToken, s.client)
+
+	// GitHub is only called back when there are credentials to call it with
+	githubClient := newHTTPClient(http.DefaultTransport, cfg.Retry, cfg.Timeouts.GitHubMs)
+	tokens, err := github.NewTokenSource(cfg.GitHub, githubClient)
+	if err != nil {
+		return nil, err
+	}
+	if tokens != nil {
+		s.github = github.NewClient(cfg.GitHub.URL, githubClient, tokens)
+	} else if cfg.PullRequestPolicy.RequireArtifact {
+		return nil, errors.New("the pull request policy needs github credentials")
+	}
+
+	s.authors, err = NewAuthorResolver(cfg.Authors, s.lookupUser)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = cfg.Branches.validate(); err != nil {
+		return nil, err
+	}
 
-	if len(splitRef) > 0 {
-		branch = splitRef[len(splitRef)-1]
+	// The default workspace is resolved by the first push that uses it
+	s.defaultRoute = &route{cfg: RouteCfg{Workspace: cfg.Workspace, Project: cfg.Project, Branches: cfg.Branches}, svc: s}
+	if s.routes, err = s.newRoutes(cfg.Routes); err != nil {
+		return nil, err
 	}
 
-	repo = event.Repository.Name
-	repoURL = event.Repository.URL
+	if pending := queue.Len(); pending > 0 {
+		l.Log("event", "ResumeQueue", "pending", pending)
+	}
 
-	logger.Log("repo", repo, "repoURL", repoURL, "branch", branch)
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	s.workers.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go s.worker()
+	}
 
-	workspaceRef, ok := s.ValidateOrg(s.cfg.Workspace)
+	return s, nil
+}
+
+func (s *service) ReceivePush(ctx context.Context, event PushEvent) (response PushResponse, err error) {
+	logger := log.With(s.logger, "event", "ReceivePush")
+
+	// Tags and other refs have no branch to record against
+	branch, ok := branchName(event.Ref)
 	if !ok {
-		return PushResponse{Result: "workspace not found"}, errors.New("workspace not found")
+		logger.Log("repo", event.Repository.Name, "ref", event.Ref, "status", "ignored")
+		return PushResponse{Result: "ignored"}, nil
 	}
 
-	// Large commits can cause Github to timeout and drop the transaction, spinning off to a goroutine allows the process to complete asynchronously
-	go func(ev PushEvent, workspaceRef string) {
-		// Get or Create Rally SCM repo
-		scmrepo, err := s.GetOrCreateSCMRepository(repo, repoURL, workspaceRef)
+	r := s.routeFor(event.Repository.FullName)
+	if !r.cfg.Branches.allows(branch) {
+		logger.Log("repo", event.Repository.Name, "branch", branch, "status", "ignored")
+		return PushResponse{Result: "ignored"}, nil
+	}
 
-		if err != nil {
-			logger.Log("GetOrCreateSCMRepository", repo, "err", err.Error())
-		}
-		userCache = make(map[string]string)
+	// Large commits can cause Github to timeout and drop the transaction, queueing the push allows the process to complete asynchronously.
+	// The push is persisted before responding, and before rally is called, so it is not lost if the service restarts or rally is down.
+	job, err := s.queue.Enqueue(PushJob{Event: event})
+	if err != nil {
+		logger.Log("repo", event.Repository.Name, "err", err.Error())
+		return PushResponse{Result: "unable to queue push"}, err
+	}
 
-		// For each commit extract the rally ID and add a changeset
-		// Create a map of formatted id's to references
-		for _, c := range event.Commits {
-			refs := s.FindRallyArtifact(c)
-			s.AddChangeSet(c, scmrepo, refs, repoURL, branch)
-		}
-		logger.Log("status", "Update rally completed")
-	}(event, workspaceRef)
+	logger.Log("repo", event.Repository.Name, "job", job.ID, "status", "queued")
 
 	return PushResponse{Result: "created"}, nil
 }
 
-func (s *service) AddChangeSet(c Commit, scmrepo string, rallyRef map[string]string, repoURL string, branch string) error {
-	author := c.Author.Email
-
-	var err error
+// ReceivePullRequest - queues a discussion post on each rally artifact referenced by a pull request when it is opened, merged or closed
+func (s *service) ReceivePullRequest(ctx context.Context, event PullRequestEvent) (response PushResponse, err error) {
+	logger := log.With(s.logger, "event", "ReceivePullRequest")
 
-	// Cache the user ref so not look up each time
-	if _, ok := userCache[author]; !ok {
-		urlString := fmt.Sprintf("%s/slm/webservice/v2.0/user", s.cfg.RallyURL)
-		req, _ := http.NewRequest(http.MethodGet, urlString, nil)
+	policy := s.cfg.PullRequestPolicy.RequireArtifact && policyAction(event.Action)
+	if pullRequestState(event) == "" && !policy {
+		return PushResponse{Result: "ignored"}, nil
+	}
 
-		params := url.Values{}
-		params.Set("query", fmt.Sprintf("(UserName = %s)", author))
+	job, err := s.queue.Enqueue(PushJob{PullRequest: &event})
+	if err != nil {
+		logger.Log("repo", event.Repository.Name, "err", err.Error())
+		return PushResponse{Result: "unable to queue pull request"}, err
+	}
 
-		req.URL.RawQuery = params.Encode()
+	logger.Log("repo", event.Repository.Name, "pr", event.Number, "job", job.ID, "status", "queued")
 
-		s.DecorateRequest(req)
+	return PushResponse{Result: "created"}, nil
+}
 
-		var rallyresponse RallyQueryResults
+// worker - processes queued pushes one at a time until the service shuts down
+func (s *service) worker() {
+	defer s.workers.wg.Done()
 
-		response, err := s.client.Do(req)
+	for {
+		if s.workers.isStopping() {
+			return
+		}
 
-		if err == nil {
-			defer response.Body.Close()
-			if err = json.NewDecoder(response.Body).Decode(&rallyresponse); err == nil {
-				results := rallyresponse.QueryResult.Results
-				if len(results) == 1 {
-					userCache[author] = results[0].Ref
-				} else {
-					userCache[author] = ""
-				}
-			} else {
-				userCache[author] = ""
+		job, ok := s.queue.Next()
+		if !ok {
+			select {
+			case <-s.queue.Ready():
+			case <-s.workers.stopping:
+				return
 			}
+			continue
+		}
+
+		s.workers.start(job)
+		if job.PullRequest != nil {
+			s.processPullRequest(s.ctx, job)
 		} else {
-			userCache[author] = ""
+			s.processPush(s.ctx, job)
+		}
+		s.workers.finish(job)
+
+		// A job cut short by closing the service stays queued and is processed again when the service starts
+		if s.ctx.Err() != nil {
+			s.logger.Log("event", "AckPush", "job", job.ID, "repo", job.repo(), "status", "interrupted")
+			return
+		}
+
+		if err := s.queue.Ack(job.ID); err != nil {
+			s.logger.Log("event", "AckPush", "job", job.ID, "err", err.Error())
 		}
 	}
+}
 
-	var artifactRefs []Reference
+func (s *service) processPush(ctx context.Context, job PushJob) {
+	event := job.Event
+	r := s.routeFor(event.Repository.FullName)
+	rs := r.svc
+	logger := log.With(s.logger, "event", "ProcessPush", "job", job.ID)
+	branch, _ := branchName(event.Ref)
 
-	if len(rallyRef) > 0 {
-		for k, v := range rallyRef {
-			artifactRefs = append(artifactRefs, Reference{Ref: v})
+	target := pushTarget{
+		Repo:     event.Repository.Name,
+		FullName: event.Repository.FullName,
+		RepoURL:  event.Repository.URL,
+		Branch:   branch,
+	}
 
-			//For each of the artifact references check and update scheduled state as required
-			starts, completes := s.checkForStatus(c.Message, k)
+	logger.Log("repo", target.Repo, "repoURL", target.RepoURL, "branch", target.Branch, "workspace", rs.cfg.Workspace)
 
-			state := ""
-			if starts {
-				state = "In-Progress"
-			}
+	commits := s.pushCommits(ctx, event, logger)
 
-			if completes {
-				state = "Completed"
-			}
+	var err error
+	target.WorkspaceRef, target.ProjectRef, err = r.resolve(ctx)
+	if err != nil {
+		log.With(logger, rallyErrorKeyvals(err)...).Log("workspace", rs.cfg.Workspace, "repo", target.FullName, "err", err.Error())
 
-			if state != "" {
-				if err := s.UpdateState(v, state); err != nil {
-					fmt.Printf("Error updating state: %s", err.Error())
-				}
-			}
+		// Nothing of the push can be written, keep every commit so the push can be re-driven once the workspace is found
+		for _, c := range commits {
+			s.deadLetter(DeadLetter{Operation: OpGetOrCreateSCMRepository, Commit: c}, target, err)
 		}
+		return
 	}
-	// Create a changeset
-	userRef := userCache[author]
-	changeSet := Changeset{
-		SCMRepository:   scmrepo,
-		Revision:        c.ID,
-		Message:         c.Message,
-		Uri:             fmt.Sprintf("%s/commit/%s", repoURL, c.ID),
-		CommitTimestamp: c.Timestamp,
+
+	var ids []artifactID
+	for _, c := range commits {
+		ids = append(ids, s.artifacts.find(c.Message)...)
 	}
 
-	if len(artifactRefs) > 0 {
-		changeSet.Artifacts = artifactRefs
+	// Get or Create Rally SCM repo
+	scmrepo, err := rs.GetOrCreateSCMRepository(ctx, target.Repo, target.RepoURL, target.WorkspaceRef, target.ProjectRef)
+
+	if err != nil {
+		log.With(logger, rallyErrorKeyvals(err)...).Log("GetOrCreateSCMRepository", target.Repo, "repo", target.FullName, "err", err.Error())
+
+		// The artifacts are only looked up to say which commits were not recorded
+		var found map[string]string
+		if s.cfg.CommitStatus {
+			found = rs.resolveArtifacts(ctx, ids, target.WorkspaceRef)
+		}
+
+		// Without the repository none of the changesets can be written, keep every commit so the push can be re-driven
+		for _, c := range commits {
+			s.deadLetter(DeadLetter{Operation: OpGetOrCreateSCMRepository, Commit: c}, target, err)
+
+			if s.cfg.CommitStatus {
+				rs.reportCommit(ctx, c, event.Repository.FullName, event.Installation.ID, s.commitRefs(c, found), nil, err, logger)
+			}
+		}
+		return
 	}
+	target.SCMRepo = scmrepo
 
-	if userRef != "" {
-		changeSet.Author = userRef
+	// Look up the artifacts of every commit together, so an id mentioned in many commits is only queried once
+	found := rs.resolveArtifacts(ctx, ids, target.WorkspaceRef)
+
+	// For each commit extract the rally ID and add a changeset
+	for _, c := range commits {
+		refs := s.commitRefs(c, found)
+		updates, err := rs.AddChangeSet(ctx, c, target, refs)
+		if err != nil {
+			log.With(logger, rallyErrorKeyvals(err)...).Log("AddChangeSet", c.ID, "repo", target.FullName, "err", err.Error())
+			s.deadLetter(DeadLetter{Operation: OpAddChangeSet, Commit: c}, target, err)
+		}
+
+		if s.cfg.CommitStatus {
+			rs.reportCommit(ctx, c, event.Repository.FullName, event.Installation.ID, refs, updates, err, logger)
+		}
 	}
+	logger.Log("status", "Update rally completed")
+}
 
-	createBody := map[string]interface{}{
-		"Changeset": changeSet,
+// commitRefs - the formatted ids in the commit's message mapped to the references found for them
+func (s *service) commitRefs(c Commit, found map[string]string) map[string]string {
+	refs := make(map[string]string)
+	for _, id := range s.artifacts.find(c.Message) {
+		if ref, ok := found[id.FormattedID]; ok {
+			refs[id.FormattedID] = ref
+		}
 	}
+	return refs
+}
 
-	b, _ := json.Marshal(createBody)
-	createRequest, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/slm/webservice/v2.0/changeset/create", s.cfg.RallyURL), bytes.NewBuffer(b))
-	s.DecorateRequest(createRequest)
+func (s *service) processPullRequest(ctx context.Context, job PushJob) {
+	event := job.PullRequest
+	pr := event.PullRequest
+	state := pullRequestState(*event)
+	r := s.routeFor(event.Repository.FullName)
 
-	createResponse, err := s.client.Do(createRequest)
+	logger := log.With(s.logger, "event", "ProcessPullRequest", "job", job.ID)
+	logger.Log("repo", event.Repository.Name, "pr", event.Number, "state", state, "workspace", r.cfg.Workspace)
 
+	workspaceRef, _, err := r.resolve(ctx)
 	if err != nil {
-		return err
-	}
-	defer createResponse.Body.Close()
-	var rallyCreateResponse RallyCreateResult
-	if err = json.NewDecoder(createResponse.Body).Decode(&rallyCreateResponse); err != nil {
-		return err
+		logger.Log("err", err.Error())
+		return
 	}
 
-	changeSetRef := rallyCreateResponse.CreateResult.Object.Ref
+	if s.cfg.PullRequestPolicy.RequireArtifact && policyAction(event.Action) {
+		s.checkPolicy(ctx, *event, r, workspaceRef, logger)
+	}
 
-	if changeSetRef == "" {
-		return errors.New("unable to create changeset")
+	// Only checked against the policy
+	if state == "" {
+		return
 	}
 
-	// Add changes from commit to changeset
-	// For each added, modifed, removed create a change
-	for _, a := range c.Added {
-		if err := s.AddChange("A", changeSetRef, a, fmt.Sprintf("%s/blob/%s/%s", repoURL, branch, a)); err != nil {
-			fmt.Printf("Error adding change: %s", err.Error())
-		}
+	target := pushTarget{
+		Repo:         event.Repository.Name,
+		FullName:     event.Repository.FullName,
+		RepoURL:      event.Repository.HTMLURL,
+		Branch:       pr.Head.Ref,
+		WorkspaceRef: workspaceRef,
 	}
 
-	for _, m := range c.Modified {
-		if err := s.AddChange("M", changeSetRef, m, fmt.Sprintf("%s/blob/%s/%s", repoURL, branch, m)); err != nil {
-			fmt.Printf("Error adding change: %s", err.Error())
+	refs := r.svc.findArtifacts(ctx, strings.Join([]string{pr.Title, pr.Body, pr.Head.Ref}, "\n"), workspaceRef)
+
+	text := fmt.Sprintf(`Pull request <a href="%s">%s#%d %s</a> was %s by %s.`,
+		html.EscapeString(pr.HTMLURL),
+		html.EscapeString(event.Repository.FullName),
+		event.Number,
+		html.EscapeString(pr.Title),
+		state,
+		html.EscapeString(event.Sender.Login),
+	)
+
+	for id, ref := range refs {
+		if err := r.svc.AddConversationPost(ctx, workspaceRef, ref, text); err != nil {
+			log.With(logger, rallyErrorKeyvals(err)...).Log("AddConversationPost", id, "repo", target.FullName, "err", err.Error())
+			s.deadLetter(DeadLetter{Operation: OpAddConversationPost, Artifact: ref, Text: text}, target, err)
 		}
 	}
+	logger.Log("status", "Update rally completed", "artifacts", len(refs))
+}
 
-	for _, r := range c.Removed {
-		if err := s.AddChange("R", changeSetRef, r, fmt.Sprintf("%s/blob/%s/%s", repoURL, branch, r)); err != nil {
-			fmt.Printf("Error adding change: %s", err.Error())
+// pullRequestState - the state reported to rally for a pull request action, empty if the action is not reported
+func pullRequestState(event PullRequestEvent) string {
+	switch event.Action {
+	case "opened", "reopened":
+		return event.Action
+	case "closed":
+		if event.PullRequest.Merged {
+			return "merged"
 		}
+		return "closed"
 	}
-	return err
+	return ""
 }
 
-// UpdateState - updates schedulestate in rally
-func (s *service) UpdateState(ref string, state string) (err error) {
-
-	parts := strings.Split(ref, "/")
-	storyID := parts[len(parts)-1]
-
-	updatePayload := map[string]interface{}{
-		"HierarchicalRequirement": map[string]interface{}{
-			"ScheduleState": state,
-		},
+// deadLetter - records a rally operation that could not be completed
+func (s *service) deadLetter(letter DeadLetter, target pushTarget, cause error) {
+	// Calls cancelled by closing the service are not failures, the job they belong to is processed again
+	if s.ctx.Err() != nil {
+		return
 	}
 
-	b, _ := json.Marshal(updatePayload)
-	updateRequest, _ := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/slm/webservice/v2.0/hierarchicalrequirement/%s", s.cfg.RallyURL, storyID), bytes.NewBuffer(b))
-	s.DecorateRequest(updateRequest)
-
-	updateResponse, err := s.client.Do(updateRequest)
+	letter.Error = cause.Error()
+	letter.RallyError, _ = cause.(*wsapi.RallyError)
+	letter.Repo = target.Repo
+	letter.FullName = target.FullName
+	letter.RepoURL = target.RepoURL
+	letter.Branch = target.Branch
+	letter.WorkspaceRef = target.WorkspaceRef
+	letter.ProjectRef = target.ProjectRef
+	letter.SCMRepo = target.SCMRepo
 
+	letter, err := s.deadLetters.Add(letter)
 	if err != nil {
+		s.logger.Log("event", "DeadLetter", "operation", letter.Operation, "commit", letter.Commit.ID, "err", err.Error())
 		return
 	}
-	defer updateResponse.Body.Close()
-	var updateResult UpdateResult
 
-	if err = json.NewDecoder(updateResponse.Body).Decode(&updateResult); err != nil {
-		return err
+	log.With(s.logger, rallyErrorKeyvals(cause)...).Log("event", "DeadLetter", "id", letter.ID, "operation", letter.Operation, "repo", letter.Repo, "commit", letter.Commit.ID, "cause", letter.Error)
+}
+
+// rallyErrorKeyvals - log keyvals saying what Rally was asked to do when err is a Rally error, none otherwise.
+// The messages Rally gave are already part of the error.
+func rallyErrorKeyvals(err error) []interface{} {
+	rallyErr, ok := err.(*wsapi.RallyError)
+	if !ok {
+		return nil
 	}
 
-	if updateResult.OperationResult.Object.ScheduleState != state {
-		return fmt.Errorf("failed to update state - %s", updateResult.OperationResult.Errors)
+	keyvals := []interface{}{"rally_status", rallyErr.StatusCode, "rally_operation", rallyErr.Operation, "rally_type", rallyErr.ObjectType}
+	if len(rallyErr.Warnings) > 0 {
+		keyvals = append(keyvals, "rally_warnings", strings.Join(rallyErr.Warnings, "; "))
 	}
 
-	return
+	return keyvals
 }
 
-func (s *service) AddChange(action string, changeset string, path string, uri string) error {
-	var err error
+// CacheStats - hit and miss counts of the service's caches
+func (s *service) CacheStats() []CacheStats {
+	return []CacheStats{s.users.Stats(), s.artifactRefs.Stats(), s.scmRepos.Stats()}
+}
+
+// LimiterStats - calls made to rally with each api key and how long they waited for the rate limit
+func (s *service) LimiterStats() []LimiterStats {
+	return s.limits.Stats()
+}
 
-	createBody := map[string]interface{}{
-		"Change": map[string]interface{}{
-			"Action":          action,
-			"Changeset":       changeset,
-			"PathAndFilename": path,
-			"Uri":             uri,
-		},
+// DeadLetters - lists the rally operations that failed after all retries
+func (s *service) DeadLetters(ctx context.Context) ([]DeadLetter, error) {
+	return s.deadLetters.List(), nil
+}
+
+// Redrive - runs a dead lettered operation again, the dead letter is removed when it succeeds
+func (s *service) Redrive(ctx context.Context, id string) error {
+	letter, ok := s.deadLetters.Get(id)
+	if !ok {
+		return ErrNotFound
 	}
 
-	b, _ := json.Marshal(createBody)
-	createRequest, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/slm/webservice/v2.0/change/create", s.cfg.RallyURL), bytes.NewBuffer(b))
-	s.DecorateRequest(createRequest)
+	r := s.routeFor(letter.FullName)
+	rs := r.svc
+	target := pushTarget{
+		Repo:         letter.Repo,
+		FullName:     letter.FullName,
+		RepoURL:      letter.RepoURL,
+		Branch:       letter.Branch,
+		WorkspaceRef: letter.WorkspaceRef,
+		ProjectRef:   letter.ProjectRef,
+		SCMRepo:      letter.SCMRepo,
+	}
 
-	createResponse, err := s.client.Do(createRequest)
+	var err error
 
-	if err != nil {
-		return err
+	switch letter.Operation {
+	case OpGetOrCreateSCMRepository, OpAddChangeSet:
+		// A push whose workspace could not be found is dead lettered without one
+		if target.WorkspaceRef == "" {
+			target.WorkspaceRef, target.ProjectRef, err = r.resolve(ctx)
+		}
+		if err == nil && letter.Operation == OpGetOrCreateSCMRepository {
+			target.SCMRepo, err = rs.GetOrCreateSCMRepository(ctx, target.Repo, target.RepoURL, target.WorkspaceRef, target.ProjectRef)
+		}
+		if err == nil {
+			_, err = rs.writeChangeSet(ctx, letter.Commit, target, rs.findArtifacts(ctx, letter.Commit.Message, target.WorkspaceRef), false)
+		}
+	case OpAddChange:
+		err = rs.AddChange(ctx, target.WorkspaceRef, letter.Action, letter.Changeset, letter.Path, letter.URI)
+	case OpUpdateState:
+		err = rs.UpdateState(ctx, letter.Artifact, letter.Fields)
+	case OpAddConversationPost:
+		err = rs.AddConversationPost(ctx, target.WorkspaceRef, letter.Artifact, letter.Text)
+	default:
+		err = fmt.Errorf("unknown operation %s", letter.Operation)
 	}
-	defer createResponse.Body.Close()
-	var rallyCreateResponse RallyCreateResult
-	if err = json.NewDecoder(createResponse.Body).Decode(&rallyCreateResponse); err != nil {
+
+	if err != nil {
+		letter.Error = err.Error()
+		letter.RallyError, _ = err.(*wsapi.RallyError)
+		letter.Attempts++
+		s.deadLetters.Update(letter)
 		return err
 	}
 
-	return err
+	return s.deadLetters.Remove(letter.ID)
 }
 
-func (s *service) ValidateOrg(orgname string) (string, bool) {
-	urlString := fmt.Sprintf("%s/slm/webservice/v2.0/workspace", s.cfg.RallyURL)
-	req, _ := http.NewRequest(http.MethodGet, urlString, nil)
+// AddChangeSet - records the commit against the artifacts in rallyRef and moves any the commit message asks to, the outcome of each
+// state change is returned whether or not the changeset is written. A commit that is already recorded is not moved again.
+func (s *service) AddChangeSet(ctx context.Context, c Commit, target pushTarget, rallyRef map[string]string) ([]stateUpdate, error) {
+	return s.writeChangeSet(ctx, c, target, rallyRef, true)
+}
 
-	params := url.Values{}
-	params.Set("query", fmt.Sprintf("(name = %s)", orgname))
+// writeChangeSet - records the commit, moving the artifacts only when moveStates is set. A re-driven changeset is not moved,
+// its state changes were made when the push was processed and any that failed are dead lettered on their own.
+func (s *service) writeChangeSet(ctx context.Context, c Commit, target pushTarget, rallyRef map[string]string, moveStates bool) (updates []stateUpdate, err error) {
+	userRef, source := s.authors.Resolve(ctx, c)
+	if source == "" {
+		source = "unresolved"
+	}
+	s.logger.Log("event", "ResolveAuthor", "commit", c.ID, "author", c.Author.Email, "login", c.Author.Username, "source", source)
 
-	req.URL.RawQuery = params.Encode()
+	var artifactRefs []Reference
+	for _, v := range rallyRef {
+		artifactRefs = append(artifactRefs, Reference{Ref: v})
+	}
 
-	s.DecorateRequest(req)
+	// Redelivered webhooks and merged branches bring the same commit again, add any new artifacts to the existing changeset instead of
+	// duplicating it, and leave the artifacts' states alone as they may have moved on since the commit was first recorded
+	existingRef, findErr := s.findChangeSet(ctx, target.WorkspaceRef, c.ID, target.SCMRepo)
+	if findErr == nil && existingRef != "" {
+		if len(artifactRefs) == 0 {
+			return nil, nil
+		}
+		return nil, s.addChangeSetArtifacts(ctx, existingRef, artifactRefs)
+	}
 
-	var rallyresponse RallyQueryResults
-	response, err := s.client.Do(req)
+	// The states are still moved when the lookup fails, the commit is dead lettered and is not moved when it is re-driven
+	if moveStates {
+		updates = s.updateStates(ctx, c, target, rallyRef)
+	}
+	if findErr != nil {
+		return updates, findErr
+	}
 
-	if err != nil {
-		return "", false
+	// Create a changeset
+	changeSet := Changeset{
+		SCMRepository:   target.SCMRepo,
+		Revision:        c.ID,
+		Message:         c.Message,
+		Uri:             fmt.Sprintf("%s/commit/%s", target.RepoURL, c.ID),
+		CommitTimestamp: c.Timestamp,
+		Branch:          target.Branch,
 	}
-	defer response.Body.Close()
-	if err = json.NewDecoder(response.Body).Decode(&rallyresponse); err != nil {
-		return "", false
+
+	if len(artifactRefs) > 0 {
+		changeSet.Artifacts = artifactRefs
 	}
 
-	results := rallyresponse.QueryResult.Results
-	if len(results) == 1 {
-		return results[0].Ref, true
+	if userRef != "" {
+		changeSet.Author = userRef
 	}
 
-	return "", false
-}
+	created, err := s.rally.Create(ctx, target.WorkspaceRef, "Changeset", changeSet)
+	if err != nil {
+		return updates, err
+	}
 
-func (s *service) GetOrCreateSCMRepository(repo string, repoURL string, workspace string) (string, error) {
-	urlString := fmt.Sprintf("%s/slm/webservice/v2.0/scmrepository", s.cfg.RallyURL)
-	req, _ := http.NewRequest(http.MethodGet, urlString, nil)
+	changeSetRef := created.Ref()
 
-	params := url.Values{}
-	params.Set("query", fmt.Sprintf("(name = %s)", repo))
+	// Add changes from commit to changeset
+	// For each added, modifed, removed create a change
+	changes := []struct {
+		action string
+		paths  []string
+	}{
+		{"A", c.Added},
+		{"M", c.Modified},
+		{"R", c.Removed},
+	}
+
+	// Link to the file at the commit rather than the branch so the link still works once the branch moves on or is deleted.
+	// A removed file no longer exists at the commit so it is linked at the commit's first parent, which GitHub resolves.
+	for _, change := range changes {
+		revision := c.ID
+		if change.action == "R" {
+			revision = c.ID + "^"
+		}
 
-	req.URL.RawQuery = params.Encode()
+		for _, p := range change.paths {
+			uri := fmt.Sprintf("%s/blob/%s/%s", target.RepoURL, revision, p)
+			if err := s.AddChange(ctx, target.WorkspaceRef, change.action, changeSetRef, p, uri); err != nil {
+				s.deadLetter(DeadLetter{Operation: OpAddChange, Commit: c, Changeset: changeSetRef, Action: change.action, Path: p, URI: uri}, target, err)
+			}
+		}
+	}
+	return updates, nil
+}
 
-	s.DecorateRequest(req)
+// updateStates - moves the artifacts in rallyRef the commit message asks to, failures are dead lettered
+func (s *service) updateStates(ctx context.Context, c Commit, target pushTarget, rallyRef map[string]string) (updates []stateUpdate) {
+	for k, v := range rallyRef {
+		transition := s.keywords.transition(c.Message, k)
+		if transition == "" {
+			continue
+		}
 
-	var rallyresponse RallyQueryResults
-	response, err := s.client.Do(req)
+		artifactType, _ := wsapi.ParseRef(v)
+		fields := s.states[artifactType][transition]
+		if len(fields) == 0 {
+			s.logger.Log("event", "UpdateState", "artifact", k, "type", artifactType, "transition", transition, "message", "no state configured")
+			continue
+		}
 
+		err := s.UpdateState(ctx, v, fields)
+		if err != nil {
+			s.deadLetter(DeadLetter{Operation: OpUpdateState, Commit: c, Artifact: v, Fields: fields}, target, err)
+		}
+		updates = append(updates, stateUpdate{FormattedID: k, Ref: v, Transition: transition, Err: err})
+	}
+
+	return updates
+}
+
+// findChangeSet - returns the ref of the changeset already recorded for revision in the SCM repository of workspaceRef, empty if there is none
+func (s *service) findChangeSet(ctx context.Context, workspaceRef string, revision string, scmrepo string) (string, error) {
+	results, err := s.rally.Query(ctx, wsapi.Query{
+		Type:      "changeset",
+		Where:     wsapi.And(wsapi.Equal("Revision", revision), wsapi.Equal("SCMRepository", scmrepo)),
+		Workspace: workspaceRef,
+		Limit:     1,
+	})
 	if err != nil {
 		return "", err
 	}
-	defer response.Body.Close()
-	if err = json.NewDecoder(response.Body).Decode(&rallyresponse); err != nil {
-		return "", err
+
+	if len(results) > 0 {
+		return results[0].Ref(), nil
 	}
 
-	results := rallyresponse.QueryResult.Results
-	if len(results) == 1 {
-		return results[0].Ref, nil
+	return "", nil
+}
+
+// addChangeSetArtifacts - adds artifacts to an existing changeset, artifacts already on the changeset are left as they are
+func (s *service) addChangeSetArtifacts(ctx context.Context, changeset string, artifacts []Reference) error {
+	_, err := s.rally.AddToCollection(ctx, changeset, "Artifacts", artifacts)
+	return err
+}
+
+// UpdateState - sets the field values on the artifact, the update is wrapped in the artifact's type taken from its ref
+func (s *service) UpdateState(ctx context.Context, ref string, fields FieldValues) error {
+	updated, err := s.rally.Update(ctx, ref, fields)
+	if err != nil {
+		return err
 	}
 
-	createBody := map[string]interface{}{
-		"SCMRepository": map[string]interface{}{
-			"SCMType":     "GitHub",
-			"Name":        repo,
-			"Workspace":   workspace,
-			"Description": "GitHub-Service push Changesets",
-			"Uri":         repoURL,
-		},
+	// Reference fields such as a portfolio item State come back as objects and are not compared
+	for field, value := range fields {
+		switch current := updated[field].(type) {
+		case string, bool, float64:
+			if current != value {
+				return fmt.Errorf("failed to update state - %s is %v", field, current)
+			}
+		}
 	}
 
-	b, _ := json.Marshal(createBody)
-	createRequest, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/create", urlString), bytes.NewBuffer(b))
-	s.DecorateRequest(createRequest)
+	return nil
+}
+
+// AddChange - adds a file change to a changeset in workspaceRef
+func (s *service) AddChange(ctx context.Context, workspaceRef string, action string, changeset string, path string, uri string) error {
+	_, err := s.rally.Create(ctx, workspaceRef, "Change", map[string]interface{}{
+		"Action":          action,
+		"Changeset":       changeset,
+		"PathAndFilename": path,
+		"Uri":             uri,
+	})
+
+	return err
+}
 
-	createResponse, err := s.client.Do(createRequest)
+// AddConversationPost - adds a discussion entry to a rally artifact in workspaceRef
+func (s *service) AddConversationPost(ctx context.Context, workspaceRef string, artifact string, text string) error {
+	_, err := s.rally.Create(ctx, workspaceRef, "ConversationPost", map[string]interface{}{
+		"Artifact": artifact,
+		"Text":     text,
+	})
 
+	return err
+}
+
+// lookupUser - returns the ref of the single rally user matching query, empty if there is not exactly one.
+// Results are cached across pushes, a user that is not found is looked up again once the negative result expires.
+func (s *service) lookupUser(ctx context.Context, query string) string {
+	if ref, ok := s.users.Get(query); ok {
+		return ref
+	}
+
+	// Two are enough to tell whether the user is ambiguous
+	results, err := s.rally.Query(ctx, wsapi.Query{Type: "user", Where: wsapi.Expr(query), Limit: 2})
 	if err != nil {
-		return "", err
+		return ""
 	}
-	defer createResponse.Body.Close()
-	var rallyCreateResponse RallyCreateResult
-	if err = json.NewDecoder(createResponse.Body).Decode(&rallyCreateResponse); err != nil {
-		return "", err
+
+	ref := ""
+	if len(results) == 1 {
+		ref = results[0].Ref()
 	}
+	s.users.Set(query, ref)
 
-	return rallyCreateResponse.CreateResult.Object.Ref, nil
+	return ref
 }
 
-func (s *service) DecorateRequest(req *http.Request) {
-	req.Header.Set("ZSESSIONID", s.cfg.APIToken)
-}
+func (s *service) ValidateOrg(ctx context.Context, orgname string) (string, bool) {
+	results, err := s.rally.Query(ctx, wsapi.Query{Type: "workspace", Where: wsapi.Equal("name", wsapi.Quote(orgname)), Limit: 2})
+	if err != nil {
+		return "", false
+	}
 
-// CheckForStatus - function takes a string as an argument and returns booleans for start and complete if the keywords are found
-func (s *service) checkForStatus(message string, artifactID string) (start bool, complete bool) {
-	start = false
-	complete = false
+	if len(results) == 1 {
+		return results[0].Ref(), true
+	}
 
-	var (
-		startRegexString     = `(STARTS|BEGINS)\s` + artifactID
-		completesRegexString = `(COMPLETES|FINISHES)\s` + artifactID
-	)
+	return "", false
+}
 
-	startRegex := regexp.MustCompile(startRegexString)
-	completeRegex := regexp.MustCompile(completesRegexString)
+// GetOrCreateSCMRepository - finds the repository by its url within the workspace, creating it in the project when it does not exist.
+// Repositories are looked up by url as names are only unique within a GitHub org. When several have the url the first is used rather
+// than creating another. The reference is cached once found.
+func (s *service) GetOrCreateSCMRepository(ctx context.Context, repo string, repoURL string, workspace string, project string) (string, error) {
+	cacheKey := workspace + " " + repoURL
+	if ref, ok := s.scmRepos.Get(cacheKey); ok {
+		return ref, nil
+	}
+
+	results, err := s.rally.Query(ctx, wsapi.Query{
+		Type:      "scmrepository",
+		Where:     wsapi.Equal("Uri", wsapi.Quote(repoURL)),
+		Workspace: workspace,
+		Limit:     2,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if len(results) > 0 {
+		if len(results) > 1 {
+			s.logger.Log("event", "GetOrCreateSCMRepository", "repoURL", repoURL, "workspace", workspace, "status", "more than one repository has the url, using the first")
+		}
+		s.scmRepos.Set(cacheKey, results[0].Ref())
+		return results[0].Ref(), nil
+	}
 
-	if startRegex.MatchString(message) {
-		start = true
+	scmRepository := map[string]interface{}{
+		"SCMType":     "GitHub",
+		"Name":        repo,
+		"Workspace":   workspace,
+		"Description": "GitHub-Service push Changesets",
+		"Uri":         repoURL,
+	}
+	if project != "" {
+		scmRepository["Projects"] = []Reference{{Ref: project}}
 	}
 
-	if completeRegex.MatchString(message) {
-		complete = true
+	created, err := s.rally.Create(ctx, workspace, "SCMRepository", scmRepository)
+	if err != nil {
+		return "", err
 	}
 
-	return
+	ref := created.Ref()
+	s.scmRepos.Set(cacheKey, ref)
+
+	return ref, nil
 }
 
-func (s *service) FindRallyArtifact(commit Commit) (artifacts map[string]string) {
-	typeMap := map[string]string{
-		"D":  "defect",
-		"DE": "defect",
-		"DS": "defectsuite",
-		"TA": "task",
-		"TC": "testcase",
-		"S":  "hierarchicalrequirement",
-		"US": "hierarchicalrequirement",
-	}
-	var (
-		artifactRegexString = `(D|DE|DS|TA|TC|S|US)\d+`
-		artifactID          string
-		artifactType        string
-	)
-	artifactRegex := regexp.MustCompile(artifactRegexString)
+func (s *service) FindRallyArtifact(ctx context.Context, commit Commit) (artifacts map[string]string) {
+	return s.findArtifacts(ctx, commit.Message, "")
+}
 
-	if artifactRegex.MatchString(commit.Message) {
-		result_slice := artifactRegex.FindAllStringSubmatch(commit.Message, -1)
+// findArtifacts - looks up the rally references for each formatted id found in text, within workspaceRef when it is set
+func (s *service) findArtifacts(ctx context.Context, text string, workspaceRef string) (artifacts map[string]string) {
+	ids := s.artifacts.find(text)
 
-		if len(result_slice) > 0 {
-			artifacts = make(map[string]string, len(result_slice))
-			for _, v := range result_slice {
-				if len(v) > 0 {
-					artifactID = v[0]
-					artifactType = v[1]
+	if len(ids) == 0 {
+		return artifacts
+	}
 
-					urlString := fmt.Sprintf("%s/slm/webservice/v2.0/%s", s.cfg.RallyURL, typeMap[artifactType])
-					req, _ := http.NewRequest(http.MethodGet, urlString, nil)
+	return s.resolveArtifacts(ctx, ids, workspaceRef)
+}
 
-					params := url.Values{}
-					params.Set("query", fmt.Sprintf("(FormattedID = %s)", artifactID))
+// resolveArtifacts - returns the references of the artifacts that exist, keyed by formatted id. Cached references are used where possible
+// and the rest are queried with one request per type per batch. Artifacts that could not be queried are left out and not cached.
+// Formatted ids are only unique within a workspace so the cache is keyed by both.
+func (s *service) resolveArtifacts(ctx context.Context, ids []artifactID, workspaceRef string) map[string]string {
+	artifacts := make(map[string]string, len(ids))
+	byType := make(map[string][]string)
 
-					req.URL.RawQuery = params.Encode()
+	for _, id := range ids {
+		if _, ok := artifacts[id.FormattedID]; ok {
+			continue
+		}
+		if ref, ok := s.artifactRefs.Get(workspaceRef + " " + id.FormattedID); ok {
+			if ref != "" {
+				artifacts[id.FormattedID] = ref
+			}
+			continue
+		}
+		// Placeholder so an id repeated across commits is only queried once, dropped below if it is not found
+		artifacts[id.FormattedID] = ""
+		byType[id.Type] = append(byType[id.Type], id.FormattedID)
+	}
 
-					s.DecorateRequest(req)
+	// Query the types in a fixed order so requests are predictable
+	types := make([]string, 0, len(byType))
+	for t := range byType {
+		types = append(types, t)
+	}
+	sort.Strings(types)
 
-					var rallyresponse RallyQueryResults
-					response, err := s.client.Do(req)
-					if err != nil {
-						continue
-					}
-					defer response.Body.Close()
-					if err = json.NewDecoder(response.Body).Decode(&rallyresponse); err != nil {
-						continue
-					}
+	for _, t := range types {
+		formattedIDs := byType[t]
+		for len(formattedIDs) > 0 {
+			n := len(formattedIDs)
+			if n > artifactBatchSize {
+				n = artifactBatchSize
+			}
+			batch := formattedIDs[:n]
+			formattedIDs = formattedIDs[n:]
 
-					if rallyresponse.QueryResult.TotalResultCount == 0 {
-						continue
-					}
+			found, err := s.queryArtifacts(ctx, t, batch, workspaceRef)
+			if err != nil {
+				s.logger.Log("event", "QueryArtifacts", "type", t, "err", err.Error())
+				continue
+			}
 
-					artifacts[artifactID] = rallyresponse.QueryResult.Results[0].Ref
-				}
+			for _, id := range batch {
+				artifacts[id] = found[id]
+				s.artifactRefs.Set(workspaceRef+" "+id, found[id])
 			}
 		}
+	}
 
+	for id, ref := range artifacts {
+		if ref == "" {
+			delete(artifacts, id)
+		}
 	}
 
 	return artifacts
+}
+
+// queryArtifacts - finds the references of formatted ids of a single type in one request
+func (s *service) queryArtifacts(ctx context.Context, artifactType string, formattedIDs []string, workspaceRef string) (map[string]string, error) {
+	terms := make([]wsapi.Expr, len(formattedIDs))
+	for i, id := range formattedIDs {
+		terms[i] = wsapi.Equal("FormattedID", id)
+	}
+
+	results, err := s.rally.Query(ctx, wsapi.Query{
+		Type:      artifactType,
+		Where:     wsapi.Or(terms...),
+		Fetch:     []string{"FormattedID"},
+		Workspace: workspaceRef,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]string, len(results))
+	for _, r := range results {
+		found[r.String("FormattedID")] = r.Ref()
+	}
+
+	// A single id does not need the formatted id to tell the results apart
+	if len(formattedIDs) == 1 && len(results) == 1 {
+		found[formattedIDs[0]] = results[0].Ref()
+	}
 
+	return found, nil
 }
@@ -22,23 +22,21 @@ package rally_test
 import (
 	"bytes"
 	"context"
-	"crypto"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha1"
-	"encoding/base64"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/comcast/github-rally-hook/rally"
-	"github.com/dgrijalva/jwt-go"
 	"github.com/go-kit/kit/log"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/ghttp"
+	"hash"
 	"io/ioutil"
 	"net/http"
-	"time"
 )
 
 var _ = Describe("A service connector for rally and github", func() {
@@ -91,6 +89,11 @@ var _ = Describe("A service connector for rally and github", func() {
 					Skip(err.Error())
 				}
 
+				gcs, err := ioutil.ReadFile("../fixtures/success_getChangeSet_none.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
 				chset, err := ioutil.ReadFile("../fixtures/success_createChangeSet.json")
 				if err != nil {
 					Skip(err.Error())
@@ -132,6 +135,11 @@ var _ = Describe("A service connector for rally and github", func() {
 						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/user"),
 						ghttp.RespondWith(http.StatusOK, string(u[:])),
 					),
+					// Changeset get
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/changeset"),
+						ghttp.RespondWith(http.StatusOK, string(gcs[:])),
+					),
 					// create changeset response
 					ghttp.CombineHandlers(
 						ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/changeset/create"),
@@ -149,17 +157,17 @@ var _ = Describe("A service connector for rally and github", func() {
 					Workspace: "Comcast",
 				}
 				ctx = context.Background()
-				svc = rally.NewPushReceiveService(log.NewNopLogger(), cfg)
+				svc, err = rally.NewPushReceiveService(log.NewNopLogger(), cfg)
+				Expect(err).ShouldNot(HaveOccurred())
 			})
 			It("should create the changeset and changes without error", func() {
 				pushResponse, err = svc.ReceivePush(ctx, pushEvent)
 				fmt.Println(pushResponse)
 				Expect(err).ShouldNot(HaveOccurred())
-				// Adding a small sleep to let the goroutine complete
-				time.Sleep(1 * time.Second)
+				Eventually(server.ReceivedRequests).Should(HaveLen(7))
 			})
 		})
-		Context("when called with a valid event and STARTS in the commit message", func() {
+		Context("when more than one SCM repository has the url", func() {
 			BeforeEach(func() {
 				// Read in JSON files
 				w, err := ioutil.ReadFile("../fixtures/success_getWorkspace.json")
@@ -167,7 +175,7 @@ var _ = Describe("A service connector for rally and github", func() {
 					Skip(err.Error())
 				}
 
-				gs, err := ioutil.ReadFile("../fixtures/success_getSCMRepo.json")
+				gs, err := ioutil.ReadFile("../fixtures/success_getSCMRepo_duplicate.json")
 				if err != nil {
 					Skip(err.Error())
 				}
@@ -181,10 +189,12 @@ var _ = Describe("A service connector for rally and github", func() {
 				if err != nil {
 					Skip(err.Error())
 				}
-				ups, err := ioutil.ReadFile("../fixtures/success_updateStateStarts.json")
+
+				gcs, err := ioutil.ReadFile("../fixtures/success_getChangeSet_none.json")
 				if err != nil {
 					Skip(err.Error())
 				}
+
 				chset, err := ioutil.ReadFile("../fixtures/success_createChangeSet.json")
 				if err != nil {
 					Skip(err.Error())
@@ -226,14 +236,19 @@ var _ = Describe("A service connector for rally and github", func() {
 						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/user"),
 						ghttp.RespondWith(http.StatusOK, string(u[:])),
 					),
-					// Update scheduledstate
+					// Changeset get
 					ghttp.CombineHandlers(
-						ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/hierarchicalrequirement/271167421104"),
-						ghttp.RespondWith(http.StatusOK, string(ups[:])),
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/changeset"),
+						ghttp.RespondWith(http.StatusOK, string(gcs[:])),
 					),
-					// create changeset response
+					// create changeset response, recorded in the first repository
 					ghttp.CombineHandlers(
 						ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/changeset/create"),
+						func(w http.ResponseWriter, r *http.Request) {
+							var body map[string]map[string]interface{}
+							Expect(json.NewDecoder(r.Body).Decode(&body)).Should(Succeed())
+							Expect(body["Changeset"]["SCMRepository"]).Should(Equal("https://rally1.rallydev.com/slm/webservice/v2.0/scmrepository/123456789"))
+						},
 						ghttp.RespondWith(http.StatusOK, string(chset[:])),
 					),
 					// create change response
@@ -242,25 +257,113 @@ var _ = Describe("A service connector for rally and github", func() {
 						ghttp.RespondWith(http.StatusOK, string(ch[:])),
 					),
 				)
+				cfg = rally.Config{
+					RallyURL:  server.URL(),
+					APIToken:  "1234abcde",
+					Workspace: "Comcast",
+				}
+				ctx = context.Background()
+				svc, err = rally.NewPushReceiveService(log.NewNopLogger(), cfg)
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+			It("should use the first rather than create another", func() {
+				pushResponse, err = svc.ReceivePush(ctx, pushEvent)
+				Expect(err).ShouldNot(HaveOccurred())
+				Eventually(server.ReceivedRequests).Should(HaveLen(7))
+			})
+		})
+		Context("when a commit removes a file", func() {
+			BeforeEach(func() {
+				w, err := ioutil.ReadFile("../fixtures/success_getWorkspace.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				gs, err := ioutil.ReadFile("../fixtures/success_getSCMRepo.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				u, err := ioutil.ReadFile("../fixtures/success_getUser.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				us, err := ioutil.ReadFile("../fixtures/success_getUserStory.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				gcs, err := ioutil.ReadFile("../fixtures/success_getChangeSet_none.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				chset, err := ioutil.ReadFile("../fixtures/success_createChangeSet.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				ch, err := ioutil.ReadFile("../fixtures/success_createChange.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				pushReq, err := ioutil.ReadFile("../fixtures/sample_pushevent.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				err = json.NewDecoder(bytes.NewReader(pushReq)).Decode(&pushEvent)
+				if err != nil {
+					Skip(err.Error())
+				}
+				pushEvent.Commits[0].Removed = []string{"config/old_jobs.go"}
 
+				changeURI := func(uri string) http.HandlerFunc {
+					return func(w http.ResponseWriter, r *http.Request) {
+						var body map[string]map[string]string
+						Expect(json.NewDecoder(r.Body).Decode(&body)).Should(Succeed())
+						Expect(body["Change"]["Uri"]).Should(Equal(uri))
+					}
+				}
+
+				server.AppendHandlers(
+					ghttp.RespondWith(http.StatusOK, string(w[:])),
+					ghttp.RespondWith(http.StatusOK, string(gs[:])),
+					ghttp.RespondWith(http.StatusOK, string(us[:])),
+					ghttp.RespondWith(http.StatusOK, string(u[:])),
+					ghttp.RespondWith(http.StatusOK, string(gcs[:])),
+					ghttp.RespondWith(http.StatusOK, string(chset[:])),
+					// The modified file is linked at the commit
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/change/create"),
+						changeURI(pushEvent.Repository.URL+"/blob/"+pushEvent.Commits[0].ID+"/config/jobs.go"),
+						ghttp.RespondWith(http.StatusOK, string(ch[:])),
+					),
+					// The removed file is linked at the commit's parent
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/change/create"),
+						changeURI(pushEvent.Repository.URL+"/blob/"+pushEvent.Commits[0].ID+"^/config/old_jobs.go"),
+						ghttp.RespondWith(http.StatusOK, string(ch[:])),
+					),
+				)
 				cfg = rally.Config{
 					RallyURL:  server.URL(),
 					APIToken:  "1234abcde",
 					Workspace: "Comcast",
 				}
-				pushEvent.Commits[0].Message = "STARTS US12345 - misnamed CompletionPercentage"
 				ctx = context.Background()
-				svc = rally.NewPushReceiveService(log.NewNopLogger(), cfg)
+				svc, err = rally.NewPushReceiveService(log.NewNopLogger(), cfg)
+				Expect(err).ShouldNot(HaveOccurred())
 			})
-			It("should update the status in rally and not return an error", func() {
+			It("should link each change to the file at a fixed revision", func() {
 				pushResponse, err = svc.ReceivePush(ctx, pushEvent)
-				fmt.Println(pushResponse)
 				Expect(err).ShouldNot(HaveOccurred())
-				// Adding a small sleep to let the goroutine complete
-				time.Sleep(1 * time.Second)
+				Eventually(server.ReceivedRequests).Should(HaveLen(8))
 			})
 		})
-		Context("when called with a valid event and FINISHES in the commit message", func() {
+		Context("when called with a valid event and STARTS in the commit message", func() {
 			BeforeEach(func() {
 				// Read in JSON files
 				w, err := ioutil.ReadFile("../fixtures/success_getWorkspace.json")
@@ -282,10 +385,15 @@ var _ = Describe("A service connector for rally and github", func() {
 				if err != nil {
 					Skip(err.Error())
 				}
-				ups, err := ioutil.ReadFile("../fixtures/success_updateState.json")
+				ups, err := ioutil.ReadFile("../fixtures/success_updateStateStarts.json")
 				if err != nil {
 					Skip(err.Error())
 				}
+				gcs, err := ioutil.ReadFile("../fixtures/success_getChangeSet_none.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
 				chset, err := ioutil.ReadFile("../fixtures/success_createChangeSet.json")
 				if err != nil {
 					Skip(err.Error())
@@ -327,6 +435,11 @@ var _ = Describe("A service connector for rally and github", func() {
 						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/user"),
 						ghttp.RespondWith(http.StatusOK, string(u[:])),
 					),
+					// Changeset get
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/changeset"),
+						ghttp.RespondWith(http.StatusOK, string(gcs[:])),
+					),
 					// Update scheduledstate
 					ghttp.CombineHandlers(
 						ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/hierarchicalrequirement/271167421104"),
@@ -343,58 +456,115 @@ var _ = Describe("A service connector for rally and github", func() {
 						ghttp.RespondWith(http.StatusOK, string(ch[:])),
 					),
 				)
+
 				cfg = rally.Config{
 					RallyURL:  server.URL(),
 					APIToken:  "1234abcde",
 					Workspace: "Comcast",
 				}
-				pushEvent.Commits[0].Message = "COMPLETES US12345 - misnamed CompletionPercentage"
+				pushEvent.Commits[0].Message = "STARTS US12345 - misnamed CompletionPercentage"
 				ctx = context.Background()
-				svc = rally.NewPushReceiveService(log.NewNopLogger(), cfg)
+				svc, err = rally.NewPushReceiveService(log.NewNopLogger(), cfg)
+				Expect(err).ShouldNot(HaveOccurred())
 			})
 			It("should update the status in rally and not return an error", func() {
 				pushResponse, err = svc.ReceivePush(ctx, pushEvent)
 				fmt.Println(pushResponse)
 				Expect(err).ShouldNot(HaveOccurred())
-				// Adding a small sleep to let the goroutine complete
-				time.Sleep(1 * time.Second)
+				Eventually(server.ReceivedRequests).Should(HaveLen(8))
 			})
 		})
-	})
-	Describe(".FindRallyArtifact", func() {
-		Context("when called with a commit message with more than one rally id", func() {
-			var commit rally.Commit
-
+		Context("when called with a valid event and FINISHES in the commit message", func() {
 			BeforeEach(func() {
+				// Read in JSON files
+				w, err := ioutil.ReadFile("../fixtures/success_getWorkspace.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				gs, err := ioutil.ReadFile("../fixtures/success_getSCMRepo.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				u, err := ioutil.ReadFile("../fixtures/success_getUser.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
 				us, err := ioutil.ReadFile("../fixtures/success_getUserStory.json")
 				if err != nil {
 					Skip(err.Error())
 				}
-				ta, err := ioutil.ReadFile("../fixtures/success_getTask.json")
+				ups, err := ioutil.ReadFile("../fixtures/success_updateState.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+				gcs, err := ioutil.ReadFile("../fixtures/success_getChangeSet_none.json")
 				if err != nil {
 					Skip(err.Error())
 				}
 
-				cmt, err := ioutil.ReadFile("../fixtures/sample_commit_multiArtifact.json")
+				chset, err := ioutil.ReadFile("../fixtures/success_createChangeSet.json")
 				if err != nil {
 					Skip(err.Error())
 				}
 
-				err = json.NewDecoder(bytes.NewReader(cmt)).Decode(&commit)
+				ch, err := ioutil.ReadFile("../fixtures/success_createChange.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				pushReq, err := ioutil.ReadFile("../fixtures/sample_pushevent.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				err = json.NewDecoder(bytes.NewReader(pushReq)).Decode(&pushEvent)
 				if err != nil {
 					Skip(err.Error())
 				}
 
 				server.AppendHandlers(
+					//Workspace get
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/workspace"),
+						ghttp.RespondWith(http.StatusOK, string(w[:])),
+					),
+					//SCMRepo Get
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/scmrepository"),
+						ghttp.RespondWith(http.StatusOK, string(gs[:])),
+					),
 					// User story get
 					ghttp.CombineHandlers(
 						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/hierarchicalrequirement"),
 						ghttp.RespondWith(http.StatusOK, string(us[:])),
 					),
-					// Task get
+					// User get
 					ghttp.CombineHandlers(
-						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/task"),
-						ghttp.RespondWith(http.StatusOK, string(ta[:])),
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/user"),
+						ghttp.RespondWith(http.StatusOK, string(u[:])),
+					),
+					// Changeset get
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/changeset"),
+						ghttp.RespondWith(http.StatusOK, string(gcs[:])),
+					),
+					// Update scheduledstate
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/hierarchicalrequirement/271167421104"),
+						ghttp.RespondWith(http.StatusOK, string(ups[:])),
+					),
+					// create changeset response
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/changeset/create"),
+						ghttp.RespondWith(http.StatusOK, string(chset[:])),
+					),
+					// create change response
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/change/create"),
+						ghttp.RespondWith(http.StatusOK, string(ch[:])),
 					),
 				)
 				cfg = rally.Config{
@@ -402,98 +572,59 @@ var _ = Describe("A service connector for rally and github", func() {
 					APIToken:  "1234abcde",
 					Workspace: "Comcast",
 				}
+				pushEvent.Commits[0].Message = "COMPLETES US12345 - misnamed CompletionPercentage"
 				ctx = context.Background()
-				svc = rally.NewPushReceiveService(log.NewNopLogger(), cfg)
-
+				svc, err = rally.NewPushReceiveService(log.NewNopLogger(), cfg)
+				Expect(err).ShouldNot(HaveOccurred())
 			})
-			It("should return an array of the correct number of references", func() {
-				refs := svc.FindRallyArtifact(commit)
-				Expect(len(refs)).Should(Equal(2))
+			It("should update the status in rally and not return an error", func() {
+				pushResponse, err = svc.ReceivePush(ctx, pushEvent)
+				fmt.Println(pushResponse)
+				Expect(err).ShouldNot(HaveOccurred())
+				Eventually(server.ReceivedRequests).Should(HaveLen(8))
 			})
 		})
-		Context("when called with a commit message with no rally ids", func() {
-			var commit rally.Commit
-
+		Context("when called with a valid event and COMPLETES on a task in the commit message", func() {
 			BeforeEach(func() {
-				cmt, err := ioutil.ReadFile("../fixtures/sample_commit_noArtifact.json")
+				// Read in JSON files
+				w, err := ioutil.ReadFile("../fixtures/success_getWorkspace.json")
 				if err != nil {
 					Skip(err.Error())
 				}
 
-				err = json.NewDecoder(bytes.NewReader(cmt)).Decode(&commit)
+				gs, err := ioutil.ReadFile("../fixtures/success_getSCMRepo.json")
 				if err != nil {
 					Skip(err.Error())
 				}
 
-				cfg = rally.Config{
-					RallyURL:  server.URL(),
-					APIToken:  "1234abcde",
-					Workspace: "Comcast",
+				u, err := ioutil.ReadFile("../fixtures/success_getUser.json")
+				if err != nil {
+					Skip(err.Error())
 				}
-				ctx = context.Background()
-				svc = rally.NewPushReceiveService(log.NewNopLogger(), cfg)
-
-			})
-			It("should return an empty array of references", func() {
-				refs := svc.FindRallyArtifact(commit)
-				Expect(len(refs)).Should(Equal(0))
-			})
-		})
-	})
-	Describe(".CheckHMAC", func() {
 
-		var (
-			auth        *rally.Authorizor
-			secretToken string
-			logger      log.Logger
-			err         error
-			pushEvent   rally.PushEvent
-		)
-		Context("when called with a valid HTTP_X_HUB_SIGNATURE in the header", func() {
-			BeforeEach(func() {
-				pushReq, err := ioutil.ReadFile("../fixtures/sample_pushevent.json")
+				ta, err := ioutil.ReadFile("../fixtures/success_getTask.json")
 				if err != nil {
 					Skip(err.Error())
 				}
-
-				err = json.NewDecoder(bytes.NewReader(pushReq)).Decode(&pushEvent)
+				ups, err := ioutil.ReadFile("../fixtures/success_updateTaskState.json")
 				if err != nil {
 					Skip(err.Error())
 				}
-
-				if secretToken, err = randomHex(20); err != nil {
-					Skip("unable to create token")
+				gcs, err := ioutil.ReadFile("../fixtures/success_getChangeSet_none.json")
+				if err != nil {
+					Skip(err.Error())
 				}
 
-				logger = log.NewNopLogger()
-
-				signingMethod := jwt.SigningMethodHMAC{
-					"SHA1",
-					crypto.SHA1,
+				chset, err := ioutil.ReadFile("../fixtures/success_createChangeSet.json")
+				if err != nil {
+					Skip(err.Error())
 				}
-				washit, err := json.Marshal(pushEvent)
 
-				value, err := signingMethod.Sign(string(washit[:]), []byte(secretToken))
+				ch, err := ioutil.ReadFile("../fixtures/success_createChange.json")
 				if err != nil {
 					Skip(err.Error())
 				}
 
-				fmt.Printf("Computed - %s for %s\n", value, secretToken)
-				ctx = context.WithValue(context.Background(), "X-Hub-Signature", value)
-
-				auth = &rally.Authorizor{
-					SecretToken:       secretToken,
-					SignatureRequired: true,
-					Logger:            logger,
-				}
-			})
-			It("should not return an error", func() {
-				err = auth.CheckHMAC(ctx, pushEvent)
-				Expect(err).ShouldNot(HaveOccurred())
-			})
-		})
-		Context("when called without a valid HTTP_X_HUB_SIGNATURE in the header", func() {
-			BeforeEach(func() {
 				pushReq, err := ioutil.ReadFile("../fixtures/sample_pushevent.json")
 				if err != nil {
 					Skip(err.Error())
@@ -504,22 +635,213 @@ var _ = Describe("A service connector for rally and github", func() {
 					Skip(err.Error())
 				}
 
-				logger = log.NewNopLogger()
-				ctx = context.Background()
-
-				auth = &rally.Authorizor{
-					SecretToken:       secretToken,
-					SignatureRequired: false,
-					Logger:            logger,
-				}
-			})
-			It("should not return an error", func() {
-				err = auth.CheckHMAC(ctx, pushEvent)
-				Expect(err).ShouldNot(HaveOccurred())
-			})
-		})
-		Context("when called with an invalid HTTP_X_HUB_SIGNATURE in the header", func() {
+				server.AppendHandlers(
+					//Workspace get
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/workspace"),
+						ghttp.RespondWith(http.StatusOK, string(w[:])),
+					),
+					//SCMRepo Get
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/scmrepository"),
+						ghttp.RespondWith(http.StatusOK, string(gs[:])),
+					),
+					// Task get
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/task"),
+						ghttp.RespondWith(http.StatusOK, string(ta[:])),
+					),
+					// User get
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/user"),
+						ghttp.RespondWith(http.StatusOK, string(u[:])),
+					),
+					// Changeset get
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/changeset"),
+						ghttp.RespondWith(http.StatusOK, string(gcs[:])),
+					),
+					// Update task state
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/task/123456789"),
+						ghttp.VerifyBody([]byte(`{"Task":{"State":"Completed"}}`)),
+						ghttp.RespondWith(http.StatusOK, string(ups[:])),
+					),
+					// create changeset response
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/changeset/create"),
+						ghttp.RespondWith(http.StatusOK, string(chset[:])),
+					),
+					// create change response
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/change/create"),
+						ghttp.RespondWith(http.StatusOK, string(ch[:])),
+					),
+				)
+				cfg = rally.Config{
+					RallyURL:  server.URL(),
+					APIToken:  "1234abcde",
+					Workspace: "Comcast",
+				}
+				pushEvent.Commits[0].Message = "COMPLETES TA12345 - completing task"
+				ctx = context.Background()
+				svc, err = rally.NewPushReceiveService(log.NewNopLogger(), cfg)
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+			It("should update the task state rather than a schedule state", func() {
+				pushResponse, err = svc.ReceivePush(ctx, pushEvent)
+				Expect(err).ShouldNot(HaveOccurred())
+				Eventually(server.ReceivedRequests).Should(HaveLen(8))
+				Expect(svc.DeadLetters(ctx)).Should(BeEmpty())
+			})
+		})
+		Context("when called with a configured keyword in the commit message", func() {
+			BeforeEach(func() {
+				// Read in JSON files
+				w, err := ioutil.ReadFile("../fixtures/success_getWorkspace.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				gs, err := ioutil.ReadFile("../fixtures/success_getSCMRepo.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				u, err := ioutil.ReadFile("../fixtures/success_getUser.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				us, err := ioutil.ReadFile("../fixtures/success_getUserStory.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+				gcs, err := ioutil.ReadFile("../fixtures/success_getChangeSet_none.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				chset, err := ioutil.ReadFile("../fixtures/success_createChangeSet.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				ch, err := ioutil.ReadFile("../fixtures/success_createChange.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				pushReq, err := ioutil.ReadFile("../fixtures/sample_pushevent.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				err = json.NewDecoder(bytes.NewReader(pushReq)).Decode(&pushEvent)
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				server.AppendHandlers(
+					//Workspace get
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/workspace"),
+						ghttp.RespondWith(http.StatusOK, string(w[:])),
+					),
+					//SCMRepo Get
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/scmrepository"),
+						ghttp.RespondWith(http.StatusOK, string(gs[:])),
+					),
+					// User story get
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/hierarchicalrequirement"),
+						ghttp.RespondWith(http.StatusOK, string(us[:])),
+					),
+					// User get
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/user"),
+						ghttp.RespondWith(http.StatusOK, string(u[:])),
+					),
+					// Changeset get
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/changeset"),
+						ghttp.RespondWith(http.StatusOK, string(gcs[:])),
+					),
+					// Update to the configured state
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/hierarchicalrequirement/271167421104"),
+						ghttp.VerifyBody([]byte(`{"HierarchicalRequirement":{"ScheduleState":"Accepted"}}`)),
+						ghttp.RespondWith(http.StatusOK, `{"OperationResult":{"Errors":[],"Object":{"ScheduleState":"Accepted"}}}`),
+					),
+					// create changeset response
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/changeset/create"),
+						ghttp.RespondWith(http.StatusOK, string(chset[:])),
+					),
+					// create change response
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/change/create"),
+						ghttp.RespondWith(http.StatusOK, string(ch[:])),
+					),
+				)
+				cfg = rally.Config{
+					RallyURL:  server.URL(),
+					APIToken:  "1234abcde",
+					Workspace: "Comcast",
+					Keywords: map[string]string{
+						"Accepts": "accept",
+					},
+					States: rally.StateCfg{
+						"hierarchicalrequirement": {
+							"accept": {"ScheduleState": "Accepted"},
+						},
+					},
+				}
+				pushEvent.Commits[0].Message = "Starts US12345 and accepts: US12345 - misnamed CompletionPercentage"
+				ctx = context.Background()
+				svc, err = rally.NewPushReceiveService(log.NewNopLogger(), cfg)
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+			It("should match the last keyword ignoring case and punctuation", func() {
+				pushResponse, err = svc.ReceivePush(ctx, pushEvent)
+				Expect(err).ShouldNot(HaveOccurred())
+				Eventually(server.ReceivedRequests).Should(HaveLen(8))
+				Expect(svc.DeadLetters(ctx)).Should(BeEmpty())
+			})
+		})
+		Context("when called with a commit that has already been recorded", func() {
 			BeforeEach(func() {
+				w, err := ioutil.ReadFile("../fixtures/success_getWorkspace.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				gs, err := ioutil.ReadFile("../fixtures/success_getSCMRepo.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				u, err := ioutil.ReadFile("../fixtures/success_getUser.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				us, err := ioutil.ReadFile("../fixtures/success_getUserStory.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				gcs, err := ioutil.ReadFile("../fixtures/success_getChangeSet.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				add, err := ioutil.ReadFile("../fixtures/success_addChangeSetArtifacts.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
 				pushReq, err := ioutil.ReadFile("../fixtures/sample_pushevent.json")
 				if err != nil {
 					Skip(err.Error())
@@ -530,12 +852,577 @@ var _ = Describe("A service connector for rally and github", func() {
 					Skip(err.Error())
 				}
 
-				if secretToken, err = randomHex(20); err != nil {
-					Skip("unable to create token")
+				server.AppendHandlers(
+					//Workspace get
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/workspace"),
+						ghttp.RespondWith(http.StatusOK, string(w[:])),
+					),
+					//SCMRepo Get
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/scmrepository"),
+						ghttp.RespondWith(http.StatusOK, string(gs[:])),
+					),
+					// User story get
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/hierarchicalrequirement"),
+						ghttp.RespondWith(http.StatusOK, string(us[:])),
+					),
+					// User get
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/user"),
+						ghttp.RespondWith(http.StatusOK, string(u[:])),
+					),
+					// Changeset get
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/changeset"),
+						ghttp.RespondWith(http.StatusOK, string(gcs[:])),
+					),
+					// add artifacts to the existing changeset
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/changeset/271264525170/Artifacts/add"),
+						ghttp.VerifyBody([]byte(`{"CollectionItems":[{"_ref":"https://rally1.rallydev.com/slm/webservice/v2.0/hierarchicalrequirement/271167421104"}]}`)),
+						ghttp.RespondWith(http.StatusOK, string(add[:])),
+					),
+				)
+				cfg = rally.Config{
+					RallyURL:  server.URL(),
+					APIToken:  "1234abcde",
+					Workspace: "Comcast",
+				}
+				// The story may have moved on since the commit was recorded
+				pushEvent.Commits[0].Message = "STARTS US12345 - misnamed CompletionPercentage"
+				ctx = context.Background()
+				svc, err = rally.NewPushReceiveService(log.NewNopLogger(), cfg)
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+			It("should add the artifacts to the existing changeset without creating another or moving the story again", func() {
+				pushResponse, err = svc.ReceivePush(ctx, pushEvent)
+				Expect(err).ShouldNot(HaveOccurred())
+				Eventually(server.ReceivedRequests).Should(HaveLen(6))
+				Consistently(server.ReceivedRequests).Should(HaveLen(6))
+			})
+		})
+		Context("when the same author pushes twice", func() {
+			BeforeEach(func() {
+				w, err := ioutil.ReadFile("../fixtures/success_getWorkspace.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				gs, err := ioutil.ReadFile("../fixtures/success_getSCMRepo.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				u, err := ioutil.ReadFile("../fixtures/success_getUser.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				us, err := ioutil.ReadFile("../fixtures/success_getUserStory.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				gcs, err := ioutil.ReadFile("../fixtures/success_getChangeSet.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				add, err := ioutil.ReadFile("../fixtures/success_addChangeSetArtifacts.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				pushReq, err := ioutil.ReadFile("../fixtures/sample_pushevent.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				err = json.NewDecoder(bytes.NewReader(pushReq)).Decode(&pushEvent)
+				if err != nil {
+					Skip(err.Error())
 				}
 
-				logger = log.NewNopLogger()
-				ctx = context.WithValue(context.Background(), "X-Hub-Signature", "somerandostring")
+				server.AppendHandlers(
+					// First push
+					ghttp.RespondWith(http.StatusOK, string(w[:])),
+					ghttp.RespondWith(http.StatusOK, string(gs[:])),
+					ghttp.RespondWith(http.StatusOK, string(us[:])),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/user"),
+						ghttp.RespondWith(http.StatusOK, string(u[:])),
+					),
+					ghttp.RespondWith(http.StatusOK, string(gcs[:])),
+					ghttp.RespondWith(http.StatusOK, string(add[:])),
+					// Second push, the workspace, repository, story and user come from the cache
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/changeset"),
+						ghttp.RespondWith(http.StatusOK, string(gcs[:])),
+					),
+					ghttp.RespondWith(http.StatusOK, string(add[:])),
+				)
+				cfg = rally.Config{
+					RallyURL:  server.URL(),
+					APIToken:  "1234abcde",
+					Workspace: "Comcast",
+				}
+				ctx = context.Background()
+				svc, err = rally.NewPushReceiveService(log.NewNopLogger(), cfg)
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+			It("should look the story and user up in rally only once", func() {
+				_, err = svc.ReceivePush(ctx, pushEvent)
+				Expect(err).ShouldNot(HaveOccurred())
+				Eventually(server.ReceivedRequests).Should(HaveLen(6))
+
+				_, err = svc.ReceivePush(ctx, pushEvent)
+				Expect(err).ShouldNot(HaveOccurred())
+				Eventually(server.ReceivedRequests).Should(HaveLen(8))
+
+				Expect(svc.CacheStats()).Should(ContainElement(rally.CacheStats{Name: "user", Hits: 1, Misses: 1}))
+				Expect(svc.CacheStats()).Should(ContainElement(rally.CacheStats{Name: "artifact", Hits: 1, Misses: 1}))
+			})
+		})
+	})
+	Describe("ReceivePullRequest", func() {
+		var (
+			prEvent      rally.PullRequestEvent
+			pushResponse rally.PushResponse
+			err          error
+		)
+
+		BeforeEach(func() {
+			prReq, err := ioutil.ReadFile("../fixtures/sample_pullrequest_event.json")
+			if err != nil {
+				Skip(err.Error())
+			}
+
+			err = json.NewDecoder(bytes.NewReader(prReq)).Decode(&prEvent)
+			if err != nil {
+				Skip(err.Error())
+			}
+
+			cfg = rally.Config{
+				RallyURL:  server.URL(),
+				APIToken:  "1234abcde",
+				Workspace: "Comcast",
+			}
+			ctx = context.Background()
+		})
+
+		Context("when a pull request referencing a story is merged", func() {
+			BeforeEach(func() {
+				w, err := ioutil.ReadFile("../fixtures/success_getWorkspace.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				us, err := ioutil.ReadFile("../fixtures/success_getUserStory.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				cp, err := ioutil.ReadFile("../fixtures/success_createConversationPost.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				server.AppendHandlers(
+					//Workspace get
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/workspace"),
+						ghttp.RespondWith(http.StatusOK, string(w[:])),
+					),
+					// User story get
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/hierarchicalrequirement"),
+						ghttp.RespondWith(http.StatusOK, string(us[:])),
+					),
+					// create conversation post response
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/conversationpost/create"),
+						func(w http.ResponseWriter, r *http.Request) {
+							var body map[string]map[string]string
+							Expect(json.NewDecoder(r.Body).Decode(&body)).Should(Succeed())
+							Expect(body["ConversationPost"]["Artifact"]).Should(HaveSuffix("/hierarchicalrequirement/271167421104"))
+							Expect(body["ConversationPost"]["Text"]).Should(ContainSubstring("was merged"))
+							Expect(body["ConversationPost"]["Text"]).Should(ContainSubstring(prEvent.PullRequest.HTMLURL))
+						},
+						ghttp.RespondWith(http.StatusOK, string(cp[:])),
+					),
+				)
+				svc, err = rally.NewPushReceiveService(log.NewNopLogger(), cfg)
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+			It("should post the pull request link and state on the story", func() {
+				pushResponse, err = svc.ReceivePullRequest(ctx, prEvent)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(pushResponse.Result).Should(Equal("created"))
+				Eventually(server.ReceivedRequests).Should(HaveLen(3))
+			})
+		})
+
+		Context("when the pull request action is not reported", func() {
+			BeforeEach(func() {
+				prEvent.Action = "labeled"
+				svc, err = rally.NewPushReceiveService(log.NewNopLogger(), cfg)
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+			It("should ignore the event", func() {
+				pushResponse, err = svc.ReceivePullRequest(ctx, prEvent)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(pushResponse.Result).Should(Equal("ignored"))
+				Consistently(server.ReceivedRequests).Should(BeEmpty())
+			})
+		})
+	})
+	Describe(".FindRallyArtifact", func() {
+		Context("when called with a commit message with more than one rally id", func() {
+			var commit rally.Commit
+
+			BeforeEach(func() {
+				us, err := ioutil.ReadFile("../fixtures/success_getUserStory.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+				ta, err := ioutil.ReadFile("../fixtures/success_getTask.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				cmt, err := ioutil.ReadFile("../fixtures/sample_commit_multiArtifact.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				err = json.NewDecoder(bytes.NewReader(cmt)).Decode(&commit)
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				server.AppendHandlers(
+					// User story get
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/hierarchicalrequirement"),
+						ghttp.RespondWith(http.StatusOK, string(us[:])),
+					),
+					// Task get
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/task"),
+						ghttp.RespondWith(http.StatusOK, string(ta[:])),
+					),
+				)
+				cfg = rally.Config{
+					RallyURL:  server.URL(),
+					APIToken:  "1234abcde",
+					Workspace: "Comcast",
+				}
+				ctx = context.Background()
+				svc, err = rally.NewPushReceiveService(log.NewNopLogger(), cfg)
+				Expect(err).ShouldNot(HaveOccurred())
+
+			})
+			It("should return an array of the correct number of references", func() {
+				refs := svc.FindRallyArtifact(context.Background(), commit)
+				Expect(len(refs)).Should(Equal(2))
+			})
+		})
+		Context("when called with several ids of the same type", func() {
+			var commit rally.Commit
+
+			BeforeEach(func() {
+				us, err := ioutil.ReadFile("../fixtures/success_getUserStories.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				commit.Message = "US12345 and US12346 - US99999 was removed"
+
+				server.AppendHandlers(
+					// One query for all of the stories
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/hierarchicalrequirement",
+							"fetch=FormattedID&query=%28%28%28FormattedID+%3D+US12345%29+OR+%28FormattedID+%3D+US12346%29%29+OR+%28FormattedID+%3D+US99999%29%29"),
+						ghttp.RespondWith(http.StatusOK, string(us[:])),
+					),
+				)
+				cfg = rally.Config{
+					RallyURL:  server.URL(),
+					APIToken:  "1234abcde",
+					Workspace: "Comcast",
+				}
+				svc, err = rally.NewPushReceiveService(log.NewNopLogger(), cfg)
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+			It("should query them together and answer again from the cache", func() {
+				refs := svc.FindRallyArtifact(context.Background(), commit)
+				Expect(refs).Should(Equal(map[string]string{
+					"US12345": "https://rally1.rallydev.com/slm/webservice/v2.0/hierarchicalrequirement/271167421104",
+					"US12346": "https://rally1.rallydev.com/slm/webservice/v2.0/hierarchicalrequirement/271167421177",
+				}))
+
+				Expect(svc.FindRallyArtifact(context.Background(), commit)).Should(Equal(refs))
+				Expect(server.ReceivedRequests()).Should(HaveLen(1))
+			})
+		})
+		Context("when called with a commit message with no rally ids", func() {
+			var commit rally.Commit
+
+			BeforeEach(func() {
+				cmt, err := ioutil.ReadFile("../fixtures/sample_commit_noArtifact.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				err = json.NewDecoder(bytes.NewReader(cmt)).Decode(&commit)
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				cfg = rally.Config{
+					RallyURL:  server.URL(),
+					APIToken:  "1234abcde",
+					Workspace: "Comcast",
+				}
+				ctx = context.Background()
+				svc, err = rally.NewPushReceiveService(log.NewNopLogger(), cfg)
+				Expect(err).ShouldNot(HaveOccurred())
+
+			})
+			It("should return an empty array of references", func() {
+				refs := svc.FindRallyArtifact(context.Background(), commit)
+				Expect(len(refs)).Should(Equal(0))
+			})
+		})
+		Context("when called with a commit message with ids inside other words", func() {
+			var commit rally.Commit
+
+			BeforeEach(func() {
+				commit.Message = "Bump ADS123 and 9f2US4a1 - no rally ids here"
+
+				cfg = rally.Config{
+					RallyURL:  server.URL(),
+					APIToken:  "1234abcde",
+					Workspace: "Comcast",
+				}
+				var err error
+				svc, err = rally.NewPushReceiveService(log.NewNopLogger(), cfg)
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+			It("should not look up any artifacts", func() {
+				refs := svc.FindRallyArtifact(context.Background(), commit)
+				Expect(refs).Should(BeEmpty())
+				Expect(server.ReceivedRequests()).Should(BeEmpty())
+			})
+		})
+		Context("when called with ids next to underscores and each other", func() {
+			var commit rally.Commit
+
+			BeforeEach(func() {
+				us, err := ioutil.ReadFile("../fixtures/success_getUserStories.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				commit.Message = "Merge branch 'feature/US12345_login'\n\nUS12346 US99999"
+
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/hierarchicalrequirement",
+							"fetch=FormattedID&query=%28%28%28FormattedID+%3D+US12345%29+OR+%28FormattedID+%3D+US12346%29%29+OR+%28FormattedID+%3D+US99999%29%29"),
+						ghttp.RespondWith(http.StatusOK, string(us[:])),
+					),
+				)
+				cfg = rally.Config{
+					RallyURL:  server.URL(),
+					APIToken:  "1234abcde",
+					Workspace: "Comcast",
+				}
+				svc, err = rally.NewPushReceiveService(log.NewNopLogger(), cfg)
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+			It("should find each of them", func() {
+				refs := svc.FindRallyArtifact(context.Background(), commit)
+				Expect(refs).Should(Equal(map[string]string{
+					"US12345": "https://rally1.rallydev.com/slm/webservice/v2.0/hierarchicalrequirement/271167421104",
+					"US12346": "https://rally1.rallydev.com/slm/webservice/v2.0/hierarchicalrequirement/271167421177",
+				}))
+				Expect(server.ReceivedRequests()).Should(HaveLen(1))
+			})
+		})
+		Context("when configured with a portfolio item prefix", func() {
+			var commit rally.Commit
+
+			BeforeEach(func() {
+				f, err := ioutil.ReadFile("../fixtures/success_getFeature.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				commit.Message = "F42 - first slice of the feature, US12345 is not configured"
+
+				server.AppendHandlers(
+					// Feature get
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/portfolioitem/feature", "fetch=FormattedID&query=%28FormattedID+%3D+F42%29"),
+						ghttp.RespondWith(http.StatusOK, string(f[:])),
+					),
+				)
+				cfg = rally.Config{
+					RallyURL:  server.URL(),
+					APIToken:  "1234abcde",
+					Workspace: "Comcast",
+					Artifacts: rally.ArtifactCfg{
+						Types: map[string]string{
+							"F": "portfolioitem/feature",
+							"I": "portfolioitem/initiative",
+						},
+					},
+				}
+				svc, err = rally.NewPushReceiveService(log.NewNopLogger(), cfg)
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+			It("should look up the portfolio item", func() {
+				refs := svc.FindRallyArtifact(context.Background(), commit)
+				Expect(refs).Should(HaveLen(1))
+				Expect(refs["F42"]).Should(HaveSuffix("/portfolioitem/feature/271167421200"))
+			})
+		})
+		Context("when configured with a pattern that does not capture the prefix", func() {
+			It("should fail to create the service", func() {
+				_, err := rally.NewPushReceiveService(log.NewNopLogger(), rally.Config{
+					RallyURL:  server.URL(),
+					Artifacts: rally.ArtifactCfg{Pattern: `US\d+`},
+				})
+				Expect(err).Should(HaveOccurred())
+			})
+		})
+	})
+	Describe(".CheckHMAC", func() {
+
+		var (
+			auth        *rally.Authorizor
+			secretToken string
+			logger      log.Logger
+			err         error
+			pushEvent   rally.PushEvent
+			pushReq     []byte
+		)
+
+		BeforeEach(func() {
+			pushReq, err = ioutil.ReadFile("../fixtures/sample_pushevent.json")
+			if err != nil {
+				Skip(err.Error())
+			}
+
+			err = json.NewDecoder(bytes.NewReader(pushReq)).Decode(&pushEvent)
+			if err != nil {
+				Skip(err.Error())
+			}
+
+			if secretToken, err = randomHex(20); err != nil {
+				Skip("unable to create token")
+			}
+
+			logger = log.NewNopLogger()
+		})
+
+		Context("when called with a valid X-Hub-Signature-256 in the header", func() {
+			BeforeEach(func() {
+				value := "sha256=" + computeHmac(sha256.New, pushReq, []byte(secretToken))
+
+				ctx = context.WithValue(context.Background(), rally.ContextKeyRawBody, pushReq)
+				ctx = context.WithValue(ctx, rally.ContextKeySignature256, value)
+
+				auth = &rally.Authorizor{
+					SecretToken:       secretToken,
+					SignatureRequired: true,
+					Logger:            logger,
+				}
+			})
+			It("should not return an error", func() {
+				err = auth.CheckHMAC(ctx, pushEvent)
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+		})
+		Context("when called with a valid legacy X-Hub-Signature in the header", func() {
+			BeforeEach(func() {
+				value := "sha1=" + computeHmac(sha1.New, pushReq, []byte(secretToken))
+
+				ctx = context.WithValue(context.Background(), rally.ContextKeyRawBody, pushReq)
+				ctx = context.WithValue(ctx, rally.ContextKeySignature, value)
+
+				auth = &rally.Authorizor{
+					SecretToken:       secretToken,
+					SignatureRequired: true,
+					Logger:            logger,
+				}
+			})
+			It("should not return an error", func() {
+				err = auth.CheckHMAC(ctx, pushEvent)
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+		})
+		Context("when the body has been altered after signing", func() {
+			BeforeEach(func() {
+				value := "sha256=" + computeHmac(sha256.New, pushReq, []byte(secretToken))
+
+				// Re-marshaling the decoded event does not reproduce the bytes GitHub signed
+				washit, err := json.Marshal(pushEvent)
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				ctx = context.WithValue(context.Background(), rally.ContextKeyRawBody, washit)
+				ctx = context.WithValue(ctx, rally.ContextKeySignature256, value)
+
+				auth = &rally.Authorizor{
+					SecretToken:       secretToken,
+					SignatureRequired: true,
+					Logger:            logger,
+				}
+			})
+			It("should return an unauthorized error", func() {
+				err = auth.CheckHMAC(ctx, pushEvent)
+				Expect(err).Should(Equal(rally.ErrUnauthorized))
+			})
+		})
+		Context("when called without a signature and one is not required", func() {
+			BeforeEach(func() {
+				ctx = context.WithValue(context.Background(), rally.ContextKeyRawBody, pushReq)
+
+				auth = &rally.Authorizor{
+					SecretToken:       secretToken,
+					SignatureRequired: false,
+					Logger:            logger,
+				}
+			})
+			It("should not return an error", func() {
+				err = auth.CheckHMAC(ctx, pushEvent)
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+		})
+		Context("when called without a signature and one is required", func() {
+			BeforeEach(func() {
+				ctx = context.WithValue(context.Background(), rally.ContextKeyRawBody, pushReq)
+
+				auth = &rally.Authorizor{
+					SecretToken:       secretToken,
+					SignatureRequired: true,
+					Logger:            logger,
+				}
+			})
+			It("should return an unauthorized error", func() {
+				err = auth.CheckHMAC(ctx, pushEvent)
+				Expect(err).Should(Equal(rally.ErrUnauthorized))
+			})
+		})
+		Context("when called with an invalid signature in the header", func() {
+			BeforeEach(func() {
+				ctx = context.WithValue(context.Background(), rally.ContextKeyRawBody, pushReq)
+				ctx = context.WithValue(ctx, rally.ContextKeySignature256, "sha256=somerandostring")
 
 				auth = &rally.Authorizor{
 					SecretToken:       "secretoken",
@@ -559,9 +1446,8 @@ func randomHex(n int) (string, error) {
 	return hex.EncodeToString(bytes), nil
 }
 
-func computeHmac1(message []byte, secret []byte) string {
-	h := hmac.New(sha1.New, secret)
-	h.Write(message)
-	fmt.Println(h.Sum(nil))
-	return base64.URLEncoding.EncodeToString(h.Sum(nil))
+func computeHmac(h func() hash.Hash, message []byte, secret []byte) string {
+	mac := hmac.New(h, secret)
+	mac.Write(message)
+	return hex.EncodeToString(mac.Sum(nil))
 }
@@ -0,0 +1,140 @@
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rally
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultShutdownTimeout - how long shutting down waits for the pushes being written when the config does not set it
+const defaultShutdownTimeout = 20 * time.Second
+
+// ShutdownTimeout - how long shutting down waits for webhooks and pushes in flight
+func (t TimeoutCfg) ShutdownTimeout() time.Duration {
+	if t.ShutdownMs <= 0 {
+		return defaultShutdownTimeout
+	}
+	return time.Duration(t.ShutdownMs) * time.Millisecond
+}
+
+// workerState - the jobs the workers are processing, shared by the service and its copies for each route
+type workerState struct {
+	wg       sync.WaitGroup
+	stopping chan struct{}
+	stopOnce sync.Once
+	mut      sync.Mutex
+	active   map[string]PushJob
+}
+
+func newWorkerState() *workerState {
+	return &workerState{
+		stopping: make(chan struct{}),
+		active:   make(map[string]PushJob),
+	}
+}
+
+// stop - the workers finish the job they are on and take no more
+func (w *workerState) stop() {
+	w.stopOnce.Do(func() { close(w.stopping) })
+}
+
+func (w *workerState) isStopping() bool {
+	select {
+	case <-w.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+func (w *workerState) start(job PushJob) {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	w.active[job.ID] = job
+}
+
+func (w *workerState) finish(job PushJob) {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	delete(w.active, job.ID)
+}
+
+// inFlight - the jobs being processed
+func (w *workerState) inFlight() []PushJob {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+
+	jobs := make([]PushJob, 0, len(w.active))
+	for _, job := range w.active {
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+// repo - the repository a job belongs to
+func (job PushJob) repo() string {
+	if job.PullRequest != nil {
+		return job.PullRequest.Repository.FullName
+	}
+	return job.Event.Repository.FullName
+}
+
+// Shutdown - stops taking queued pushes and waits until ctx is done for the workers to finish the pushes they are writing.
+// Pushes still being written then are cancelled, they and the pushes still queued are logged and are processed on the next
+// start when the queue is kept in data_dir.
+func (s *service) Shutdown(ctx context.Context) error {
+	s.workers.stop()
+
+	done := make(chan struct{})
+	go func() {
+		s.workers.wg.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		interrupted := s.workers.inFlight()
+		for _, job := range interrupted {
+			s.logger.Log("event", "Shutdown", "job", job.ID, "repo", job.repo(), "status", "interrupted")
+		}
+		err = fmt.Errorf("%d pushes were interrupted", len(interrupted))
+
+		// Cancelled calls return straight away so the workers are not waited on for long
+		s.cancel()
+		<-done
+	}
+	s.cancel()
+
+	if queued := s.queue.Len(); queued > 0 {
+		s.logger.Log("event", "Shutdown", "queued", queued, "persisted", s.queue.dir != "")
+	}
+
+	return err
+}
+
+// Close - stops the workers and cancels the Rally and GitHub calls in flight
+func (s *service) Close() error {
+	s.workers.stop()
+	s.cancel()
+	return nil
+}
@@ -0,0 +1,178 @@
+// +build unit
+
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rally_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/comcast/github-rally-hook/rally"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var _ = Describe("Shutting down", func() {
+	var (
+		server  *ghttp.Server
+		dir     string
+		svc     rally.Service
+		release chan struct{}
+		queued  func() []string
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "push-shutdown")
+		Expect(err).ShouldNot(HaveOccurred())
+
+		queued = func() []string {
+			files, _ := filepath.Glob(filepath.Join(dir, "queue", "*.json"))
+			return files
+		}
+
+		w, err := ioutil.ReadFile("../fixtures/success_getWorkspace.json")
+		if err != nil {
+			Skip(err.Error())
+		}
+
+		gs, err := ioutil.ReadFile("../fixtures/success_getSCMRepo.json")
+		if err != nil {
+			Skip(err.Error())
+		}
+
+		fu, err := ioutil.ReadFile("../fixtures/fail_getUser.json")
+		if err != nil {
+			Skip(err.Error())
+		}
+
+		gcs, err := ioutil.ReadFile("../fixtures/success_getChangeSet_none.json")
+		if err != nil {
+			Skip(err.Error())
+		}
+
+		chset, err := ioutil.ReadFile("../fixtures/success_createChangeSet.json")
+		if err != nil {
+			Skip(err.Error())
+		}
+
+		ch, err := ioutil.ReadFile("../fixtures/success_createChange.json")
+		if err != nil {
+			Skip(err.Error())
+		}
+
+		pushReq, err := ioutil.ReadFile("../fixtures/sample_pushevent.json")
+		if err != nil {
+			Skip(err.Error())
+		}
+
+		var pushEvent rally.PushEvent
+		err = json.NewDecoder(bytes.NewReader(pushReq)).Decode(&pushEvent)
+		if err != nil {
+			Skip(err.Error())
+		}
+		pushEvent.Commits[0].Message = "Fix a typo"
+		pushEvent.Commits[0].Added = []string{"README.md"}
+		pushEvent.Commits[0].Modified = nil
+		pushEvent.Commits[0].Removed = nil
+
+		// The push is held at the repository lookup until it is released
+		release = make(chan struct{})
+
+		server = ghttp.NewServer()
+		server.AllowUnhandledRequests = false
+		server.RouteToHandler("GET", "/slm/webservice/v2.0/workspace", ghttp.RespondWith(http.StatusOK, string(w[:])))
+		server.RouteToHandler("GET", "/slm/webservice/v2.0/scmrepository", func(rw http.ResponseWriter, r *http.Request) {
+			select {
+			case <-release:
+				rw.Write(gs)
+			case <-r.Context().Done():
+			}
+		})
+		server.RouteToHandler("GET", "/slm/webservice/v2.0/user", ghttp.RespondWith(http.StatusOK, string(fu[:])))
+		server.RouteToHandler("GET", "/slm/webservice/v2.0/changeset", ghttp.RespondWith(http.StatusOK, string(gcs[:])))
+		server.RouteToHandler("POST", "/slm/webservice/v2.0/changeset/create", ghttp.RespondWith(http.StatusOK, string(chset[:])))
+		server.RouteToHandler("POST", "/slm/webservice/v2.0/change/create", ghttp.RespondWith(http.StatusOK, string(ch[:])))
+
+		svc, err = rally.NewPushReceiveService(log.NewNopLogger(), rally.Config{
+			RallyURL:  server.URL(),
+			APIToken:  "1234abcde",
+			Workspace: "Comcast",
+			DataDir:   dir,
+			Retry:     rally.RetryCfg{Attempts: 1},
+		})
+		Expect(err).ShouldNot(HaveOccurred())
+
+		_, err = svc.ReceivePush(context.Background(), pushEvent)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		// Wait for the worker to reach the repository lookup
+		Eventually(server.ReceivedRequests).Should(HaveLen(2))
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(dir)
+	})
+
+	Context("when the push finishes before the deadline", func() {
+		It("should wait for it to be written", func() {
+			shutdown := make(chan error, 1)
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				shutdown <- svc.Shutdown(ctx)
+			}()
+
+			Consistently(shutdown, 100*time.Millisecond).ShouldNot(Receive())
+			close(release)
+
+			var err error
+			Eventually(shutdown, 2*time.Second).Should(Receive(&err))
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Expect(queued()).Should(BeEmpty())
+			requests := server.ReceivedRequests()
+			Expect(requests[len(requests)-1].URL.Path).Should(Equal("/slm/webservice/v2.0/change/create"))
+		})
+	})
+
+	Context("when the push is still being written at the deadline", func() {
+		It("should cancel it and leave it queued for the next start", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+
+			err := svc.Shutdown(ctx)
+			Expect(err).Should(MatchError("1 pushes were interrupted"))
+
+			Expect(queued()).Should(HaveLen(1))
+
+			letters, err := svc.DeadLetters(context.Background())
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(letters).Should(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,135 @@
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rally
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Transitions a commit message can ask for
+const (
+	TransitionStart    = "start"
+	TransitionComplete = "complete"
+)
+
+// FieldValues - field names and the values they are set to
+type FieldValues map[string]interface{}
+
+// StateCfg - WSAPI type, e.g. "task" or "portfolioitem/feature", to the field values set for each transition
+type StateCfg map[string]map[string]FieldValues
+
+// defaultStates - stories and defects follow ScheduleState, tasks have their own State and defects are also marked Fixed when completed.
+// Portfolio item State is a reference to a State object so there is no default for them, configure the State ref to use.
+var defaultStates = StateCfg{
+	"hierarchicalrequirement": {
+		TransitionStart:    {"ScheduleState": "In-Progress"},
+		TransitionComplete: {"ScheduleState": "Completed"},
+	},
+	"defect": {
+		TransitionStart:    {"ScheduleState": "In-Progress"},
+		TransitionComplete: {"ScheduleState": "Completed", "State": "Fixed"},
+	},
+	"task": {
+		TransitionStart:    {"State": "In-Progress"},
+		TransitionComplete: {"State": "Completed"},
+	},
+}
+
+// defaultKeywords - commit message keywords and the transition they ask for
+var defaultKeywords = map[string]string{
+	"STARTS":    TransitionStart,
+	"BEGINS":    TransitionStart,
+	"COMPLETES": TransitionComplete,
+	"FINISHES":  TransitionComplete,
+}
+
+// keywordMatcher - finds the transition a commit message asks for on an artifact
+type keywordMatcher struct {
+	keywords    map[string]string
+	alternation string
+}
+
+// newKeywordMatcher - configured keywords are added to the defaults, a keyword configured with an empty transition is removed
+func newKeywordMatcher(configured map[string]string) *keywordMatcher {
+	keywords := make(map[string]string, len(defaultKeywords)+len(configured))
+	for k, t := range defaultKeywords {
+		keywords[strings.ToUpper(k)] = t
+	}
+	for k, t := range configured {
+		if t == "" {
+			delete(keywords, strings.ToUpper(k))
+			continue
+		}
+		keywords[strings.ToUpper(k)] = t
+	}
+
+	quoted := make([]string, 0, len(keywords))
+	for k := range keywords {
+		quoted = append(quoted, regexp.QuoteMeta(k))
+	}
+	sort.Strings(quoted)
+
+	return &keywordMatcher{
+		keywords:    keywords,
+		alternation: strings.Join(quoted, "|"),
+	}
+}
+
+// transition - the transition asked for by a keyword directly before artifactID, ignoring case and punctuation such as "Fixes: US123".
+// When several keywords refer to the artifact the last one wins, the id may be followed by anything but a digit as in
+// "Fixes US123_login". Empty if there is none.
+func (k *keywordMatcher) transition(message string, artifactID string) string {
+	if k.alternation == "" {
+		return ""
+	}
+
+	regex, err := regexp.Compile(`(?i)\b(` + k.alternation + `)\b[\s:;,.\-]*` + regexp.QuoteMeta(artifactID) + `(?:[^0-9]|$)`)
+	if err != nil {
+		return ""
+	}
+
+	matches := regex.FindAllStringSubmatch(message, -1)
+	if len(matches) == 0 {
+		return ""
+	}
+
+	return k.keywords[strings.ToUpper(matches[len(matches)-1][1])]
+}
+
+// mergeStates - configured transitions are added to the defaults for their type, replacing a default transition of the same name
+func mergeStates(configured StateCfg) StateCfg {
+	states := make(StateCfg, len(defaultStates)+len(configured))
+	for t, transitions := range defaultStates {
+		states[t] = make(map[string]FieldValues, len(transitions))
+		for name, fields := range transitions {
+			states[t][name] = fields
+		}
+	}
+	for t, transitions := range configured {
+		t = strings.ToLower(t)
+		if states[t] == nil {
+			states[t] = make(map[string]FieldValues, len(transitions))
+		}
+		for name, fields := range transitions {
+			states[t][name] = fields
+		}
+	}
+	return states
+}
@@ -0,0 +1,165 @@
+// +build unit
+
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package rally_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/comcast/github-rally-hook/rally"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var _ = Describe("Timing out and cancelling rally calls", func() {
+	var (
+		server *ghttp.Server
+		// hang - a rally that never answers, the handler returns once the call is abandoned
+		hang http.HandlerFunc
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		server.AllowUnhandledRequests = false
+		hang = func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Context("when rally does not answer", func() {
+		var svc rally.Service
+
+		BeforeEach(func() {
+			server.RouteToHandler("GET", "/slm/webservice/v2.0/hierarchicalrequirement", hang)
+
+			var err error
+			svc, err = rally.NewPushReceiveService(log.NewNopLogger(), rally.Config{
+				RallyURL:  server.URL(),
+				APIToken:  "1234abcde",
+				Workspace: "Comcast",
+				Retry:     rally.RetryCfg{Attempts: 1},
+				Timeouts:  rally.TimeoutCfg{RallyMs: 100},
+			})
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			svc.Close()
+		})
+
+		It("should give up once the call times out", func() {
+			start := time.Now()
+			refs := svc.FindRallyArtifact(context.Background(), rally.Commit{Message: "US12345 - Fix the build"})
+			Expect(refs).Should(BeEmpty())
+			Expect(time.Since(start)).Should(BeNumerically("<", 2*time.Second))
+		})
+
+		It("should give up once the caller's context is cancelled", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			refs := svc.FindRallyArtifact(ctx, rally.Commit{Message: "US12345 - Fix the build"})
+			Expect(refs).Should(BeEmpty())
+			Expect(ctx.Err()).Should(HaveOccurred())
+		})
+	})
+
+	Context("when the service is closed while a push is being written", func() {
+		var (
+			dir string
+			svc rally.Service
+		)
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "push-close")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			w, err := ioutil.ReadFile("../fixtures/success_getWorkspace.json")
+			if err != nil {
+				Skip(err.Error())
+			}
+
+			pushReq, err := ioutil.ReadFile("../fixtures/sample_pushevent.json")
+			if err != nil {
+				Skip(err.Error())
+			}
+
+			var pushEvent rally.PushEvent
+			err = json.NewDecoder(bytes.NewReader(pushReq)).Decode(&pushEvent)
+			if err != nil {
+				Skip(err.Error())
+			}
+
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/workspace"),
+					ghttp.RespondWith(http.StatusOK, string(w[:])),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/scmrepository"),
+					hang,
+				),
+			)
+
+			svc, err = rally.NewPushReceiveService(log.NewNopLogger(), rally.Config{
+				RallyURL:  server.URL(),
+				APIToken:  "1234abcde",
+				Workspace: "Comcast",
+				DataDir:   dir,
+				Retry:     rally.RetryCfg{Attempts: 1},
+			})
+			Expect(err).ShouldNot(HaveOccurred())
+
+			_, err = svc.ReceivePush(context.Background(), pushEvent)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			Eventually(server.ReceivedRequests).Should(HaveLen(2))
+			Expect(svc.Close()).Should(Succeed())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("should leave the push queued for the next start without dead lettering it", func() {
+			queued := func() []string {
+				files, _ := filepath.Glob(filepath.Join(dir, "queue", "*.json"))
+				return files
+			}
+			Consistently(queued, 200*time.Millisecond).Should(HaveLen(1))
+
+			letters, err := svc.DeadLetters(context.Background())
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(letters).Should(BeEmpty())
+		})
+	})
+})
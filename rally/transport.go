@@ -18,6 +18,7 @@
 package rally
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -25,6 +26,7 @@ import (
 	"github.com/go-kit/kit/log"
 	kithttp "github.com/go-kit/kit/transport/http"
 	"github.com/gorilla/mux"
+	"io/ioutil"
 	"net/http"
 )
 
@@ -39,6 +41,23 @@ var (
 	ErrInvalidToken = errors.New("token contains an invalid number of segments")
 	// ErrForbidden - returns 403 http error
 	ErrForbidden = errors.New("User not authorized for operation")
+	// ErrNotFound - returns 404 http error
+	ErrNotFound = errors.New("not found")
+)
+
+type contextKey string
+
+const (
+	// ContextKeySignature - context key for the legacy SHA1 X-Hub-Signature header
+	ContextKeySignature contextKey = "X-Hub-Signature"
+	// ContextKeySignature256 - context key for the SHA256 X-Hub-Signature-256 header
+	ContextKeySignature256 contextKey = "X-Hub-Signature-256"
+	// ContextKeyRawBody - context key for the request body exactly as it was received
+	ContextKeyRawBody contextKey = "raw-body"
+	// ContextKeyDelivery - context key for the X-GitHub-Delivery id
+	ContextKeyDelivery contextKey = "X-GitHub-Delivery"
+	// ContextKeyAuthorization - context key for the Authorization header of admin requests
+	ContextKeyAuthorization contextKey = "Authorization"
 )
 
 // MakeRoutes - make routes
@@ -46,15 +65,103 @@ func MakeRoutes(r *mux.Router, s Service, logger log.Logger, middleware endpoint
 	options := []kithttp.ServerOption{
 		kithttp.ServerErrorLogger(logger),
 		kithttp.ServerErrorEncoder(encodeError),
+		kithttp.ServerBefore(HTTPToContext()),
 		kithttp.ServerBefore(auth...),
 	}
 
 	r.Methods("POST").Path("/receive").Handler(kithttp.NewServer(
-		middleware(MakePushEventEndpoint(s)),
-		decodePushEventRequest,
+		middleware(MakeEventEndpoint(s)),
+		decodeEventRequest,
+		encodeResponse,
+		options...,
+	))
+}
+
+// MakeAdminRoutes - routes to list and re-drive dead letters, every request must carry the admin token
+func MakeAdminRoutes(r *mux.Router, s Service, logger log.Logger, adminToken string) {
+	options := []kithttp.ServerOption{
+		kithttp.ServerErrorLogger(logger),
+		kithttp.ServerErrorEncoder(encodeError),
+		kithttp.ServerBefore(authorizationToContext),
+	}
+
+	middleware := RequireToken(adminToken)
+
+	r.Methods("GET").Path("/deadletters").Handler(kithttp.NewServer(
+		middleware(MakeDeadLettersEndpoint(s)),
+		decodeDeadLettersRequest,
 		encodeResponse,
 		options...,
 	))
+
+	r.Methods("POST").Path("/deadletters/{id}/redrive").Handler(kithttp.NewServer(
+		middleware(MakeRedriveEndpoint(s)),
+		decodeRedriveRequest,
+		encodeResponse,
+		options...,
+	))
+}
+
+// HTTPToContext - moves the GitHub signatures and delivery id from the headers to the context along with the raw body the signatures were computed over.
+// The body is replaced so it can still be decoded after being read.
+func HTTPToContext() kithttp.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		body, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			r.Body = ioutil.NopCloser(bytes.NewReader(nil))
+			return ctx
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		ctx = context.WithValue(ctx, ContextKeyRawBody, body)
+
+		if token := r.Header.Get("X-Hub-Signature-256"); len(token) != 0 {
+			ctx = context.WithValue(ctx, ContextKeySignature256, token)
+		}
+
+		if token := r.Header.Get("X-Hub-Signature"); len(token) != 0 {
+			ctx = context.WithValue(ctx, ContextKeySignature, token)
+		}
+
+		if delivery := r.Header.Get("X-GitHub-Delivery"); len(delivery) != 0 {
+			ctx = context.WithValue(ctx, ContextKeyDelivery, delivery)
+		}
+
+		return ctx
+	}
+}
+
+func authorizationToContext(ctx context.Context, r *http.Request) context.Context {
+	token := r.Header.Get("Authorization")
+	if len(token) == 0 {
+		return ctx
+	}
+
+	return context.WithValue(ctx, ContextKeyAuthorization, token)
+}
+
+func decodeDeadLettersRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	return nil, nil
+}
+
+func decodeRedriveRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	id, ok := mux.Vars(r)["id"]
+	if !ok {
+		return nil, ErrBadRouting
+	}
+	return RedriveRequest{ID: id}, nil
+}
+
+// decodeEventRequest - decodes the payload according to the X-GitHub-Event header, requests without the header are treated as pushes
+func decodeEventRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	switch name := r.Header.Get("X-GitHub-Event"); name {
+	case "", "push":
+		return decodePushEventRequest(ctx, r)
+	case "pull_request":
+		return decodePullRequestEventRequest(ctx, r)
+	default:
+		return UnsupportedEvent{Name: name}, nil
+	}
 }
 
 func decodePushEventRequest(ctx context.Context, r *http.Request) (interface{}, error) {
@@ -65,6 +172,15 @@ func decodePushEventRequest(ctx context.Context, r *http.Request) (interface{},
 	}
 	return event, nil
 }
+
+func decodePullRequestEventRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	var event PullRequestEvent
+
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		return nil, ErrInvalidArgument
+	}
+	return event, nil
+}
 func encodeResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
 	if e, ok := response.(errorer); ok && e.error() != nil {
 		encodeError(ctx, e.error(), w)
@@ -89,6 +205,8 @@ func encodeError(_ context.Context, err error, w http.ResponseWriter) {
 		code = http.StatusUnauthorized
 	case ErrForbidden:
 		code = http.StatusForbidden
+	case ErrNotFound:
+		code = http.StatusNotFound
 	case ErrInvalidToken:
 		code = http.StatusUnauthorized
 	}
@@ -0,0 +1,286 @@
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package wsapi calls the Rally Web Services API v2.0.
+package wsapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// apiPath - where WSAPI v2.0 is served from under the Rally url
+const apiPath = "/slm/webservice/v2.0"
+
+// Operations a RallyError can come from
+const (
+	OpQuery           = "query"
+	OpGet             = "get"
+	OpCreate          = "create"
+	OpUpdate          = "update"
+	OpDelete          = "delete"
+	OpAddToCollection = "add"
+)
+
+// RallyError - a WSAPI call that Rally refused or failed, either with an unsuccessful status or with the Errors of the result
+type RallyError struct {
+	StatusCode int    `json:"status_code"`
+	Operation  string `json:"operation"`
+	ObjectType string `json:"object_type"`
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	// Errors and Warnings are the messages of the result, Rally only refuses a call for its errors
+	Errors   []string `json:"errors,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+func (e *RallyError) Error() string {
+	msg := http.StatusText(e.StatusCode)
+	if len(e.Errors) > 0 {
+		msg = strings.Join(e.Errors, "; ")
+	}
+	return fmt.Sprintf("rally %s %s (%d): %s", e.Operation, e.ObjectType, e.StatusCode, msg)
+}
+
+// call - a WSAPI request, what it is for is kept to describe a failure
+type call struct {
+	op         string
+	objectType string
+	method     string
+	path       string
+	params     url.Values
+	body       interface{}
+}
+
+func (c call) error(statusCode int, r result) *RallyError {
+	return &RallyError{
+		StatusCode: statusCode,
+		Operation:  c.op,
+		ObjectType: c.objectType,
+		Method:     c.method,
+		Path:       apiPath + c.path,
+		Errors:     r.Errors,
+		Warnings:   r.Warnings,
+	}
+}
+
+// Client - calls WSAPI with an api key
+type Client struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+// NewClient - client makes every call so retries and timeouts are shared with the caller, rallyURL is the server without the WSAPI path
+func NewClient(rallyURL string, apiKey string, client *http.Client) *Client {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &Client{
+		url:    strings.TrimSuffix(rallyURL, "/") + apiPath,
+		apiKey: apiKey,
+		client: client,
+	}
+}
+
+// WithAPIKey - a copy of the client calling with another api key
+func (c *Client) WithAPIKey(apiKey string) *Client {
+	cc := *c
+	cc.apiKey = apiKey
+	return &cc
+}
+
+// result - the body of a query, create or operation result
+type result struct {
+	Errors           []string `json:"Errors"`
+	Warnings         []string `json:"Warnings"`
+	TotalResultCount int      `json:"TotalResultCount"`
+	StartIndex       int      `json:"StartIndex"`
+	PageSize         int      `json:"PageSize"`
+	Results          []Object `json:"Results"`
+	Object           Object   `json:"Object"`
+}
+
+// Get - reads the object ref refers to, only the fetch fields are returned when set
+func (c *Client) Get(ctx context.Context, ref string, fetch ...string) (Object, error) {
+	objectType, objectID := ParseRef(ref)
+
+	params := url.Values{}
+	if len(fetch) > 0 {
+		params.Set("fetch", strings.Join(fetch, ","))
+	}
+
+	get := call{op: OpGet, objectType: objectType, method: http.MethodGet, path: "/" + objectType + "/" + objectID, params: params}
+
+	// The object is wrapped in its type name, a failure is an operation result
+	var body map[string]json.RawMessage
+	status, err := c.do(ctx, get, &body)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, raw := range body {
+		var r result
+		if name == "OperationResult" {
+			if err = json.Unmarshal(raw, &r); err != nil {
+				return nil, err
+			}
+			return nil, get.error(status, r)
+		}
+
+		var o Object
+		if err = json.Unmarshal(raw, &o); err != nil {
+			return nil, err
+		}
+		return o, nil
+	}
+
+	return nil, get.error(status, result{Errors: []string{"no object was returned"}})
+}
+
+// Create - creates an object of typeName, e.g. Changeset, with fields in the workspace ref. Without a workspace rally uses the api key's default workspace.
+func (c *Client) Create(ctx context.Context, workspace string, typeName string, fields interface{}) (Object, error) {
+	objectType := strings.ToLower(typeName)
+	create := call{op: OpCreate, objectType: objectType, method: http.MethodPost, path: "/" + objectType + "/create", body: map[string]interface{}{typeName: fields}}
+	if workspace != "" {
+		create.params = url.Values{"workspace": {workspace}}
+	}
+
+	status, r, err := c.operation(ctx, create, "CreateResult")
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Object.Ref() == "" {
+		return nil, create.error(status, result{Errors: []string{"no object was returned"}, Warnings: r.Warnings})
+	}
+
+	return r.Object, nil
+}
+
+// Update - sets fields on the object ref refers to and returns the object as it is after the update
+func (c *Client) Update(ctx context.Context, ref string, fields interface{}) (Object, error) {
+	objectType, objectID := ParseRef(ref)
+
+	update := call{op: OpUpdate, objectType: objectType, method: http.MethodPost, path: "/" + objectType + "/" + objectID, body: map[string]interface{}{TypeName(objectType): fields}}
+
+	_, r, err := c.operation(ctx, update, "OperationResult")
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Object, nil
+}
+
+// Delete - deletes the object ref refers to
+func (c *Client) Delete(ctx context.Context, ref string) error {
+	objectType, objectID := ParseRef(ref)
+
+	_, _, err := c.operation(ctx, call{op: OpDelete, objectType: objectType, method: http.MethodDelete, path: "/" + objectType + "/" + objectID}, "OperationResult")
+	return err
+}
+
+// AddToCollection - adds items to a collection of the object ref refers to, e.g. the Artifacts of a changeset. Items already in the collection are left as they are.
+func (c *Client) AddToCollection(ctx context.Context, ref string, collection string, items interface{}) ([]Object, error) {
+	objectType, objectID := ParseRef(ref)
+
+	add := call{
+		op:         OpAddToCollection,
+		objectType: objectType + "/" + collection,
+		method:     http.MethodPost,
+		path:       "/" + objectType + "/" + objectID + "/" + collection + "/add",
+		body:       map[string]interface{}{"CollectionItems": items},
+	}
+
+	_, r, err := c.operation(ctx, add, "OperationResult")
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Results, nil
+}
+
+// operation - makes a call whose result is wrapped in name and fails when the result has errors
+func (c *Client) operation(ctx context.Context, op call, name string) (int, result, error) {
+	var envelope map[string]result
+	status, err := c.do(ctx, op, &envelope)
+	if err != nil {
+		return status, result{}, err
+	}
+
+	r := envelope[name]
+	if len(r.Errors) > 0 {
+		return status, r, op.error(status, r)
+	}
+
+	return status, r, nil
+}
+
+// do - sends the call's body as json and decodes a successful response into v, anything else is returned as a *RallyError with the messages Rally gave
+func (c *Client) do(ctx context.Context, op call, v interface{}) (int, error) {
+	var reader io.Reader
+	if op.body != nil {
+		b, err := json.Marshal(op.body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(op.method, c.url+op.path, reader)
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+
+	if len(op.params) > 0 {
+		req.URL.RawQuery = op.params.Encode()
+	}
+
+	req.Header.Set("ZSESSIONID", c.apiKey)
+	if op.body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		// Rally explains most failures in the errors of whatever result the call would have returned
+		var messages result
+		var envelope map[string]result
+		if json.NewDecoder(response.Body).Decode(&envelope) == nil {
+			for _, r := range envelope {
+				messages.Errors = append(messages.Errors, r.Errors...)
+				messages.Warnings = append(messages.Warnings, r.Warnings...)
+			}
+		}
+		return response.StatusCode, op.error(response.StatusCode, messages)
+	}
+
+	return response.StatusCode, json.NewDecoder(response.Body).Decode(v)
+}
@@ -0,0 +1,243 @@
+// +build unit
+
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package wsapi_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/comcast/github-rally-hook/rally/wsapi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"io/ioutil"
+	"net/http"
+)
+
+var _ = Describe("Calling WSAPI", func() {
+	var (
+		server *ghttp.Server
+		client *wsapi.Client
+		ctx    context.Context
+		apiKey http.Header
+	)
+
+	BeforeEach(func() {
+		server = ghttp.NewServer()
+		server.AllowUnhandledRequests = false
+		client = wsapi.NewClient(server.URL(), "1234abcde", nil)
+		ctx = context.Background()
+		apiKey = http.Header{"Zsessionid": []string{"1234abcde"}}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe(".Get", func() {
+		Context("when the object exists", func() {
+			BeforeEach(func() {
+				st, err := ioutil.ReadFile("../../fixtures/success_getUserStoryState.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/hierarchicalrequirement/271167421104", "fetch=ScheduleState"),
+						ghttp.VerifyHeader(apiKey),
+						ghttp.RespondWith(http.StatusOK, string(st[:])),
+					),
+				)
+			})
+			It("should unwrap the object from its type name", func() {
+				o, err := client.Get(ctx, "https://rally1.rallydev.com/slm/webservice/v2.0/hierarchicalrequirement/271167421104", "ScheduleState")
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(o.Ref()).Should(Equal("https://rally1.rallydev.com/slm/webservice/v2.0/hierarchicalrequirement/271167421104"))
+				Expect(o.Name()).Should(Equal("A Test Story"))
+				Expect(o.String("ScheduleState")).Should(Equal("In-Progress"))
+			})
+		})
+
+		Context("when the object does not exist", func() {
+			BeforeEach(func() {
+				server.AppendHandlers(
+					ghttp.RespondWith(http.StatusOK, `{"OperationResult": {"Errors": ["Cannot find object to read"], "Warnings": []}}`),
+				)
+			})
+			It("should return the errors rally gave", func() {
+				_, err := client.Get(ctx, "hierarchicalrequirement/1")
+				Expect(err).Should(HaveOccurred())
+
+				apiErr, ok := err.(*wsapi.RallyError)
+				Expect(ok).Should(BeTrue())
+				Expect(apiErr.Method).Should(Equal("GET"))
+				Expect(apiErr.Path).Should(Equal("/slm/webservice/v2.0/hierarchicalrequirement/1"))
+				Expect(apiErr.Errors).Should(Equal([]string{"Cannot find object to read"}))
+			})
+		})
+	})
+
+	Describe(".Create", func() {
+		Context("when rally creates the object", func() {
+			BeforeEach(func() {
+				cs, err := ioutil.ReadFile("../../fixtures/success_createChangeSet.json")
+				if err != nil {
+					Skip(err.Error())
+				}
+
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/changeset/create", "workspace=%2Fworkspace%2F12345"),
+						ghttp.VerifyHeader(apiKey),
+						ghttp.VerifyContentType("application/json"),
+						func(w http.ResponseWriter, r *http.Request) {
+							var body map[string]map[string]interface{}
+							Expect(json.NewDecoder(r.Body).Decode(&body)).Should(Succeed())
+							Expect(body["Changeset"]["Revision"]).Should(Equal("abc123"))
+						},
+						ghttp.RespondWith(http.StatusOK, string(cs[:])),
+					),
+				)
+			})
+			It("should create the object in the workspace, wrapping the fields in the type name, and return it", func() {
+				o, err := client.Create(ctx, "/workspace/12345", "Changeset", map[string]string{"Revision": "abc123"})
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(o.Ref()).ShouldNot(BeEmpty())
+			})
+		})
+
+		Context("when rally rejects the object", func() {
+			BeforeEach(func() {
+				server.AppendHandlers(
+					ghttp.RespondWith(http.StatusOK, `{"CreateResult": {"Errors": ["Validation error: Change.PathAndFilename should not be null"], "Warnings": ["It is no longer necessary to append \".js\" to WSAPI resources."]}}`),
+				)
+			})
+			It("should return the errors and warnings", func() {
+				_, err := client.Create(ctx, "", "Change", map[string]string{})
+				Expect(err).Should(HaveOccurred())
+				Expect(err.Error()).Should(ContainSubstring("Validation error: Change.PathAndFilename should not be null"))
+
+				apiErr := err.(*wsapi.RallyError)
+				Expect(apiErr.StatusCode).Should(Equal(http.StatusOK))
+				Expect(apiErr.Operation).Should(Equal(wsapi.OpCreate))
+				Expect(apiErr.ObjectType).Should(Equal("change"))
+				Expect(apiErr.Warnings).Should(HaveLen(1))
+			})
+		})
+
+		Context("when rally does not return the object", func() {
+			BeforeEach(func() {
+				server.AppendHandlers(
+					ghttp.RespondWith(http.StatusOK, `{"CreateResult": {"Errors": [], "Warnings": []}}`),
+				)
+			})
+			It("should say what could not be created", func() {
+				_, err := client.Create(ctx, "", "SCMRepository", map[string]string{})
+				Expect(err).Should(MatchError("rally create scmrepository (200): no object was returned"))
+			})
+		})
+
+		Context("when rally does not accept the request", func() {
+			BeforeEach(func() {
+				server.AppendHandlers(
+					ghttp.RespondWith(http.StatusUnauthorized, `<html>401 Unauthorized</html>`),
+				)
+			})
+			It("should return the status", func() {
+				_, err := client.Create(ctx, "", "Change", map[string]string{})
+				Expect(err).Should(HaveOccurred())
+				Expect(err).Should(MatchError("rally create change (401): Unauthorized"))
+				Expect(err.(*wsapi.RallyError).StatusCode).Should(Equal(http.StatusUnauthorized))
+			})
+		})
+	})
+
+	Describe(".Update", func() {
+		BeforeEach(func() {
+			st, err := ioutil.ReadFile("../../fixtures/success_updateState.json")
+			if err != nil {
+				Skip(err.Error())
+			}
+
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/portfolioitem/feature/1234"),
+					ghttp.VerifyJSON(`{"PortfolioItem": {"State": "Done"}}`),
+					ghttp.RespondWith(http.StatusOK, string(st[:])),
+				),
+			)
+		})
+		It("should wrap the fields in the name of the type in the ref", func() {
+			o, err := client.Update(ctx, "https://rally1.rallydev.com/slm/webservice/v2.0/PortfolioItem/Feature/1234", map[string]string{"State": "Done"})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(o.String("ScheduleState")).Should(Equal("Completed"))
+		})
+	})
+
+	Describe(".Delete", func() {
+		BeforeEach(func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("DELETE", "/slm/webservice/v2.0/change/42"),
+					ghttp.RespondWith(http.StatusOK, `{"OperationResult": {"Errors": [], "Warnings": []}}`),
+				),
+			)
+		})
+		It("should delete the object", func() {
+			Expect(client.Delete(ctx, "change/42")).Should(Succeed())
+		})
+	})
+
+	Describe(".AddToCollection", func() {
+		BeforeEach(func() {
+			r, err := ioutil.ReadFile("../../fixtures/success_addChangeSetArtifacts.json")
+			if err != nil {
+				Skip(err.Error())
+			}
+
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("POST", "/slm/webservice/v2.0/changeset/99/Artifacts/add"),
+					ghttp.VerifyJSON(`{"CollectionItems": [{"_ref": "hierarchicalrequirement/1"}]}`),
+					ghttp.RespondWith(http.StatusOK, string(r[:])),
+				),
+			)
+		})
+		It("should return the collection's items", func() {
+			items, err := client.AddToCollection(ctx, "changeset/99", "Artifacts", []map[string]string{{"_ref": "hierarchicalrequirement/1"}})
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(items).Should(HaveLen(1))
+		})
+	})
+
+	Describe(".WithAPIKey", func() {
+		BeforeEach(func() {
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyHeader(http.Header{"Zsessionid": []string{"other-key"}}),
+					ghttp.RespondWith(http.StatusOK, `{"OperationResult": {"Errors": []}}`),
+				),
+			)
+		})
+		It("should call with the other key", func() {
+			Expect(client.WithAPIKey("other-key").Delete(ctx, "change/42")).Should(Succeed())
+		})
+	})
+})
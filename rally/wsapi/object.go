@@ -0,0 +1,86 @@
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package wsapi
+
+import (
+	"strings"
+)
+
+// Object - the fields of a WSAPI object by name, objects it refers to are nested objects with at least a _ref
+type Object map[string]interface{}
+
+// Ref - the object's reference
+func (o Object) Ref() string {
+	return o.String("_ref")
+}
+
+// Name - the name Rally gives the object, e.g. the name of a user story or the user name of a user
+func (o Object) Name() string {
+	return o.String("_refObjectName")
+}
+
+// String - the value of field when it is a string, or the name of the object it refers to, empty otherwise
+func (o Object) String(field string) string {
+	switch v := o[field].(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		return Object(v).Name()
+	}
+	return ""
+}
+
+// typeNames - the name an object is wrapped in when it is written
+var typeNames = map[string]string{
+	"hierarchicalrequirement": "HierarchicalRequirement",
+	"defect":                  "Defect",
+	"defectsuite":             "DefectSuite",
+	"task":                    "Task",
+	"testcase":                "TestCase",
+}
+
+// TypeName - the name an object of objectType is wrapped in when it is written
+func TypeName(objectType string) string {
+	if name, ok := typeNames[objectType]; ok {
+		return name
+	}
+
+	if strings.HasPrefix(objectType, "portfolioitem") {
+		return "PortfolioItem"
+	}
+
+	return strings.Title(objectType)
+}
+
+// ParseRef - splits a WSAPI ref such as .../v2.0/portfolioitem/feature/1234 into its lower case type and object id
+func ParseRef(ref string) (objectType string, objectID string) {
+	path := ref
+	if i := strings.Index(path, "/webservice/"); i >= 0 {
+		path = path[i+len("/webservice/"):]
+		// Drop the version
+		if j := strings.Index(path, "/"); j >= 0 {
+			path = path[j+1:]
+		}
+	}
+
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	objectID = parts[len(parts)-1]
+	objectType = strings.ToLower(strings.Join(parts[:len(parts)-1], "/"))
+
+	return objectType, objectID
+}
@@ -0,0 +1,128 @@
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package wsapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Expr - a WSAPI query expression such as (FormattedID = US123)
+type Expr string
+
+// Equal - field is value, values with spaces or brackets should be quoted with Quote
+func Equal(field string, value string) Expr {
+	return Compare(field, "=", value)
+}
+
+// Compare - field compared to value with op, e.g. contains or !=
+func Compare(field string, op string, value string) Expr {
+	return Expr(fmt.Sprintf("(%s %s %s)", field, op, value))
+}
+
+// And - all of exprs, WSAPI only accepts two terms per AND so they are nested
+func And(exprs ...Expr) Expr {
+	return join("AND", exprs)
+}
+
+// Or - any of exprs, WSAPI only accepts two terms per OR so they are nested
+func Or(exprs ...Expr) Expr {
+	return join("OR", exprs)
+}
+
+func join(op string, exprs []Expr) Expr {
+	var query Expr
+
+	for i, e := range exprs {
+		if i == 0 {
+			query = e
+			continue
+		}
+		query = Expr(fmt.Sprintf("(%s %s %s)", query, op, e))
+	}
+
+	return query
+}
+
+// Quote - value as a quoted string
+func Quote(value string) string {
+	return `"` + strings.Replace(value, `"`, `\"`, -1) + `"`
+}
+
+// Query - the objects of Type matching Where
+type Query struct {
+	// Type is the lower case type in the url, e.g. hierarchicalrequirement or portfolioitem/feature
+	Type      string
+	Where     Expr
+	Fetch     []string
+	Workspace string
+	Order     string
+	// PageSize is the number of objects read per request, Rally's default of 20 when it is 0
+	PageSize int
+	// Limit is the most objects returned, every page is read when it is 0
+	Limit int
+}
+
+// Query - reads the objects matching q a page at a time
+func (c *Client) Query(ctx context.Context, q Query) ([]Object, error) {
+	params := url.Values{}
+	if q.Where != "" {
+		params.Set("query", string(q.Where))
+	}
+	if len(q.Fetch) > 0 {
+		params.Set("fetch", strings.Join(q.Fetch, ","))
+	}
+	if q.Workspace != "" {
+		params.Set("workspace", q.Workspace)
+	}
+	if q.Order != "" {
+		params.Set("order", q.Order)
+	}
+	if q.PageSize > 0 {
+		params.Set("pagesize", strconv.Itoa(q.PageSize))
+	}
+
+	query := call{op: OpQuery, objectType: q.Type, method: http.MethodGet, path: "/" + q.Type, params: params}
+
+	var objects []Object
+	for {
+		_, r, err := c.operation(ctx, query, "QueryResult")
+		if err != nil {
+			return nil, err
+		}
+
+		objects = append(objects, r.Results...)
+
+		if len(r.Results) == 0 || len(objects) >= r.TotalResultCount || (q.Limit > 0 && len(objects) >= q.Limit) {
+			break
+		}
+
+		// Pages start at 1
+		params.Set("start", strconv.Itoa(len(objects)+1))
+	}
+
+	if q.Limit > 0 && len(objects) > q.Limit {
+		objects = objects[:q.Limit]
+	}
+
+	return objects, nil
+}
@@ -0,0 +1,143 @@
+// +build unit
+
+/**
+ * Copyright 2019 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package wsapi_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/comcast/github-rally-hook/rally/wsapi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"net/http"
+)
+
+var _ = Describe("Querying WSAPI", func() {
+	Describe("building expressions", func() {
+		It("should nest AND terms in pairs", func() {
+			q := wsapi.And(wsapi.Equal("Revision", "abc"), wsapi.Equal("SCMRepository", "scm/1"), wsapi.Compare("Name", "contains", "api"))
+			Expect(q).Should(Equal(wsapi.Expr("(((Revision = abc) AND (SCMRepository = scm/1)) AND (Name contains api))")))
+		})
+		It("should nest OR terms in pairs", func() {
+			q := wsapi.Or(wsapi.Equal("FormattedID", "US1"), wsapi.Equal("FormattedID", "US2"))
+			Expect(q).Should(Equal(wsapi.Expr("((FormattedID = US1) OR (FormattedID = US2))")))
+		})
+		It("should leave a single term as it is", func() {
+			Expect(wsapi.And(wsapi.Equal("Name", "x"))).Should(Equal(wsapi.Expr("(Name = x)")))
+		})
+		It("should escape quotes in values", func() {
+			Expect(wsapi.Equal("Name", wsapi.Quote(`say "hi"`))).Should(Equal(wsapi.Expr(`(Name = "say \"hi\"")`)))
+		})
+	})
+
+	Describe(".Query", func() {
+		var (
+			server *ghttp.Server
+			client *wsapi.Client
+		)
+
+		// page - a query result of count users starting at start out of total
+		page := func(start int, count int, total int) string {
+			results := ""
+			for i := start; i < start+count; i++ {
+				if results != "" {
+					results += ","
+				}
+				results += fmt.Sprintf(`{"_ref": "https://rally1.rallydev.com/slm/webservice/v2.0/user/%d", "_refObjectName": "user%d"}`, i, i)
+			}
+			return fmt.Sprintf(`{"QueryResult": {"Errors": [], "Warnings": [], "TotalResultCount": %d, "StartIndex": %d, "PageSize": %d, "Results": [%s]}}`, total, start, count, results)
+		}
+
+		BeforeEach(func() {
+			server = ghttp.NewServer()
+			server.AllowUnhandledRequests = false
+			client = wsapi.NewClient(server.URL()+"/", "1234abcde", nil)
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		Context("when the results span more than one page", func() {
+			BeforeEach(func() {
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/user", "fetch=UserName%2CEmailAddress&pagesize=2&query=%28Disabled+%3D+false%29&workspace=workspace%2F1"),
+						ghttp.RespondWith(http.StatusOK, page(1, 2, 5)),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/user", "fetch=UserName%2CEmailAddress&pagesize=2&query=%28Disabled+%3D+false%29&start=3&workspace=workspace%2F1"),
+						ghttp.RespondWith(http.StatusOK, page(3, 2, 5)),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/user", "fetch=UserName%2CEmailAddress&pagesize=2&query=%28Disabled+%3D+false%29&start=5&workspace=workspace%2F1"),
+						ghttp.RespondWith(http.StatusOK, page(5, 1, 5)),
+					),
+				)
+			})
+			It("should read every page", func() {
+				users, err := client.Query(context.Background(), wsapi.Query{
+					Type:      "user",
+					Where:     wsapi.Equal("Disabled", "false"),
+					Fetch:     []string{"UserName", "EmailAddress"},
+					Workspace: "workspace/1",
+					PageSize:  2,
+				})
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(users).Should(HaveLen(5))
+				Expect(users[4].Name()).Should(Equal("user5"))
+			})
+		})
+
+		Context("when there is a limit", func() {
+			BeforeEach(func() {
+				server.AppendHandlers(
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/user", "pagesize=2"),
+						ghttp.RespondWith(http.StatusOK, page(1, 2, 5)),
+					),
+					ghttp.CombineHandlers(
+						ghttp.VerifyRequest("GET", "/slm/webservice/v2.0/user", "pagesize=2&start=3"),
+						ghttp.RespondWith(http.StatusOK, page(3, 2, 5)),
+					),
+				)
+			})
+			It("should stop reading once it has enough", func() {
+				users, err := client.Query(context.Background(), wsapi.Query{Type: "user", PageSize: 2, Limit: 3})
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(users).Should(HaveLen(3))
+				Expect(server.ReceivedRequests()).Should(HaveLen(2))
+			})
+		})
+
+		Context("when the query is invalid", func() {
+			BeforeEach(func() {
+				server.AppendHandlers(
+					ghttp.RespondWith(http.StatusOK, `{"QueryResult": {"Errors": ["Could not parse: Could not find attribute \"Nme\""], "TotalResultCount": 0, "Results": []}}`),
+				)
+			})
+			It("should return the errors rally gave", func() {
+				_, err := client.Query(context.Background(), wsapi.Query{Type: "user", Where: wsapi.Equal("Nme", "x")})
+				Expect(err).Should(HaveOccurred())
+				Expect(err.(*wsapi.RallyError).Errors).Should(ConsistOf(`Could not parse: Could not find attribute "Nme"`))
+			})
+		})
+	})
+})
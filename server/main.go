@@ -25,12 +25,15 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
+	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
 	kitinflux "github.com/go-kit/kit/metrics/influx"
-	kithttp "github.com/go-kit/kit/transport/http"
 
 	"github.com/gorilla/mux"
 	"github.com/influxdata/influxdb/client/v2"
@@ -72,13 +75,24 @@ func main() {
 		Logger:            logger,
 	}
 
-	middleware := auth.ValidatePayload()
+	var deliveryFile string
+	if cfg.DataDir != "" {
+		deliveryFile = filepath.Join(cfg.DataDir, "deliveries.json")
+	}
 
-	authBefore := []kithttp.RequestFunc{
-		HTTPToContext(),
+	deliveries, err := rally.NewDeliveryCache(deliveryFile, cfg.Deliveries)
+	if err != nil {
+		logger.Log("event", "exiting", "err", err)
+		os.Exit(1)
 	}
 
-	receiveService := rally.NewPushReceiveService(pushLogger, cfg)
+	middleware := endpoint.Chain(auth.ValidatePayload(), rally.DeduplicateDeliveries(deliveries, logger))
+
+	receiveService, err := rally.NewPushReceiveService(pushLogger, cfg)
+	if err != nil {
+		logger.Log("event", "exiting", "err", err)
+		os.Exit(1)
+	}
 	receiveService = rally.NewLoggingService(receiveService, logger)
 
 	// Make the metrics optional based on whether config contains
@@ -97,6 +111,8 @@ func main() {
 		//influxdb connection
 		requestCounter := in.NewCounter("requests")
 		callDur := in.NewHistogram("callDur")
+		cacheGauge := in.NewGauge("cache")
+		limiterGauge := in.NewGauge("limiter")
 
 		client, err := client.NewHTTPClient(client.HTTPConfig{
 			Addr:     cfg.InfluxCfg.URL,
@@ -114,14 +130,20 @@ func main() {
 		//Our Writeloop for Batching using ticker.C channel data
 		go in.WriteLoop(ticker.C, client)
 
-		receiveService = rally.NewInstrumentedService(receiveService, requestCounter, callDur, client, in)
+		receiveService = rally.NewInstrumentedService(receiveService, requestCounter, callDur, cacheGauge, limiterGauge, client, in)
 	}
 
 	//Set up and start http server
 	r := mux.NewRouter()
 
 	apiRouter := r.PathPrefix("/api").Subrouter()
-	rally.MakeRoutes(apiRouter, receiveService, logger, middleware, authBefore...)
+	rally.MakeRoutes(apiRouter, receiveService, logger, middleware)
+
+	// The admin routes are only served when a token has been configured
+	if cfg.AdminToken != "" {
+		adminRouter := r.PathPrefix("/admin").Subrouter()
+		rally.MakeAdminRoutes(adminRouter, receiveService, logger, cfg.AdminToken)
+	}
 
 	port := os.Getenv("PORT")
 
@@ -137,12 +159,45 @@ func main() {
 		ReadTimeout:  30 * time.Second,
 	}
 
-	err := server.ListenAndServe()
+	// Stop on the signal a deploy sends, finishing the webhooks and pushes in flight first
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err = <-serveErr:
+	case sig := <-stop:
+		logger.Log("event", "shuttingDown", "signal", sig.String())
+	}
+
+	// The http server and the push workers share the one deadline
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeouts.ShutdownTimeout())
+	defer cancel()
+
+	if err == nil {
+		if shutdownErr := server.Shutdown(ctx); shutdownErr != nil {
+			logger.Log("event", "shuttingDown", "err", shutdownErr)
+		}
+	}
+
+	// Pushes cut short are still queued and are processed when the service next starts
+	if shutdownErr := receiveService.Shutdown(ctx); shutdownErr != nil {
+		logger.Log("event", "shuttingDown", "err", shutdownErr)
+	}
+	if closeErr := deliveries.Close(); closeErr != nil {
+		logger.Log("event", "shuttingDown", "err", closeErr)
+	}
 
 	if err != nil {
 		logger.Log("event", "exiting", "err", err)
 		os.Exit(1)
 	}
+
+	logger.Log("event", "exiting")
 }
 
 func newLogContext(logger log.Logger, app string) log.Logger {
@@ -167,15 +222,3 @@ func (pc pkgCaller) String() string {
 	caller = strings.TrimPrefix(caller, "github.com/comcast/github-rally-hook/")
 	return caller
 }
-
-// HTTPToContext - used to move the Github signature from the header to the context.
-func HTTPToContext() kithttp.RequestFunc {
-	return func(ctx context.Context, r *http.Request) context.Context {
-		token := r.Header.Get("X-Hub-Signature")
-		if len(token) == 0 {
-			return ctx
-		}
-
-		return context.WithValue(ctx, "X-Hub-Signature", token)
-	}
-}